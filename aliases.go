@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// modelAliases maps the names clients use to model names of the backends.
+var modelAliases map[string]*modelAlias
+
+// modelAlias is an entry of the aliases file or a model created with /api/create.
+// Besides the model, it can set defaults for the requests made with it; the
+// client's own values win.
+type modelAlias struct {
+	Model       string                 `json:"model"`
+	System      string                 `json:"system,omitempty"`
+	Temperature *float64               `json:"temperature,omitempty"`
+	MaxTokens   int                    `json:"max_tokens,omitempty"`
+	Provider    map[string]interface{} `json:"provider,omitempty"` // OpenRouter provider preferences
+	Options     map[string]interface{} `json:"options,omitempty"`  // Ollama options, e.g. from PARAMETER
+	Template    string                 `json:"template,omitempty"` // Prompt template of /api/generate
+	Messages    []modelMessage         `json:"messages,omitempty"` // Sent in front of every conversation
+
+	ModifiedAt string `json:"modified_at,omitempty"`
+}
+
+// modelMessage is a seed message of a model, as in Ollama's MESSAGE instruction.
+type modelMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// UnmarshalJSON accepts either just the model name or an object with defaults.
+func (a *modelAlias) UnmarshalJSON(data []byte) error {
+	var model string
+	if err := json.Unmarshal(data, &model); err == nil {
+		*a = modelAlias{Model: model}
+		return nil
+	}
+	type plain modelAlias
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// loadModelAliases reads the aliases file, a JSON object of alias -> model name
+// or alias -> {"model": ..., defaults}.
+func loadModelAliases(path string) (map[string]*modelAlias, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases map[string]*modelAlias
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for name, alias := range aliases {
+		if name == "" || alias == nil || alias.Model == "" {
+			return nil, fmt.Errorf("%s: alias %q needs a model", path, name)
+		}
+		if _, chained := aliases[alias.Model]; chained && alias.Model != name {
+			return nil, fmt.Errorf("%s: alias %q points to another alias %q", path, name, alias.Model)
+		}
+		if alias.MaxTokens < 0 {
+			return nil, fmt.Errorf("%s: alias %q has a negative max_tokens", path, name)
+		}
+		if err := validateProviderPreferences(alias.Provider); err != nil {
+			return nil, fmt.Errorf("%s: alias %q: %w", path, name, err)
+		}
+	}
+	return aliases, nil
+}
+
+// findAlias returns the alias or created model of the name, or nil.
+func findAlias(name string) *modelAlias {
+	if alias, ok := modelAliases[name]; ok {
+		return alias
+	}
+	return virtualModels.Get(name)
+}
+
+// promptTemplate returns the template that /api/generate requests without their own
+// template render the prompt with, "" for none.
+func (a *modelAlias) promptTemplate() string {
+	if a == nil {
+		return ""
+	}
+	return a.Template
+}
+
+// options returns the default Ollama options of the alias.
+func (a *modelAlias) options() map[string]interface{} {
+	options := make(map[string]interface{}, len(a.Options)+2)
+	for key, value := range a.Options {
+		options[key] = value
+	}
+	if a.Temperature != nil {
+		options["temperature"] = *a.Temperature
+	}
+	if a.MaxTokens > 0 {
+		options["num_predict"] = a.MaxTokens
+	}
+	return options
+}
+
+// applyDefaults sets the alias defaults the request options do not set themselves.
+func (a *modelAlias) applyDefaults(opts *RequestOptions) {
+	if a == nil {
+		return
+	}
+	options := a.options()
+	for key, value := range opts.Options {
+		options[key] = value
+	}
+	opts.Options = options
+	if opts.Provider == nil {
+		opts.Provider = a.Provider
+	}
+}
+
+// chatMessages adds the alias system prompt, unless the messages have one, and the
+// seed messages after the leading system messages.
+func (a *modelAlias) chatMessages(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	if a == nil || (a.System == "" && len(a.Messages) == 0) {
+		return messages
+	}
+
+	system := 0
+	for system < len(messages) && messages[system].Role == openai.ChatMessageRoleSystem {
+		system++
+	}
+	result := make([]openai.ChatCompletionMessage, 0, len(messages)+len(a.Messages)+1)
+	if a.System != "" && !hasSystemMessage(messages) {
+		result = append(result, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: a.System})
+	}
+	result = append(result, messages[:system]...)
+	for _, message := range a.Messages {
+		result = append(result, openai.ChatCompletionMessage{Role: message.Role, Content: message.Content})
+	}
+	return append(result, messages[system:]...)
+}
+
+func hasSystemMessage(messages []openai.ChatCompletionMessage) bool {
+	for _, message := range messages {
+		if message.Role == openai.ChatMessageRoleSystem {
+			return true
+		}
+	}
+	return false
+}
+
+// systemPrompt returns the system prompt for a generate request.
+func (a *modelAlias) systemPrompt(system string) string {
+	if system != "" || a == nil {
+		return system
+	}
+	return a.System
+}
+
+// generate runs a generate request. With seed messages or earlier turns of the
+// conversation, the request is made as a chat, as the messages have to go in front
+// of the prompt.
+func (a *modelAlias) generate(ctx context.Context, provider Provider, history []openai.ChatCompletionMessage, prompt string, modelName string, system string, images []string, opts RequestOptions) (ChatResponse, error) {
+	if (a == nil || len(a.Messages) == 0) && len(history) == 0 {
+		return provider.Generate(ctx, prompt, modelName, system, images, opts)
+	}
+	return provider.Chat(ctx, a.chatMessages(generateMessages(history, prompt, system, images)), modelName, opts)
+}
+
+// generateStream is generate for streaming requests.
+func (a *modelAlias) generateStream(ctx context.Context, provider Provider, history []openai.ChatCompletionMessage, prompt string, modelName string, system string, images []string, opts RequestOptions) (ChatCompletionStream, error) {
+	if (a == nil || len(a.Messages) == 0) && len(history) == 0 {
+		return provider.GenerateStream(ctx, prompt, modelName, system, images, opts)
+	}
+	return provider.ChatStream(ctx, a.chatMessages(generateMessages(history, prompt, system, images)), modelName, opts)
+}
+
+// show adds the alias to the /api/show response of its model.
+func (a *modelAlias) show(details map[string]interface{}) {
+	details["modelfile"] = a.modelfile()
+	if a.System != "" {
+		details["system"] = a.System
+	}
+	if a.Template != "" {
+		details["template"] = a.Template
+	}
+	if len(a.Messages) > 0 {
+		details["messages"] = a.Messages
+	}
+	if a.ModifiedAt != "" {
+		details["modified_at"] = a.ModifiedAt
+	}
+
+	// The alias options replace the model's parameters of the same name
+	options := a.options()
+	var lines []string
+	if parameters, _ := details["parameters"].(string); parameters != "" {
+		for _, line := range strings.Split(parameters, "\n") {
+			name, _, _ := strings.Cut(line, " ")
+			if _, ok := options[name]; !ok {
+				lines = append(lines, line)
+			}
+		}
+	}
+	details["parameters"] = strings.Join(append(lines, parameterLines(options)...), "\n")
+}
+
+// aliasModels returns the aliases and created models for the model lists, with the
+// details of the model they stand for if it is listed.
+func aliasModels(models []Model) []Model {
+	aliases := virtualModels.All()
+	for name, alias := range modelAliases {
+		aliases[name] = alias
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	currentTime := time.Now().Format(time.RFC3339)
+	result := make([]Model, 0, len(names))
+	for _, name := range names {
+		alias := aliases[name]
+		model := Model{
+			Name:       name,
+			Model:      name,
+			ModifiedAt: currentTime,
+			Digest:     name,
+			Details:    ModelDetails{Format: "api"},
+			id:         alias.Model,
+		}
+		for _, m := range models {
+			if m.Model == alias.Model || strings.HasSuffix(alias.Model, "/"+m.Model) {
+				model.ModifiedAt = m.ModifiedAt
+				model.Details = m.Details
+				break
+			}
+		}
+		if alias.ModifiedAt != "" {
+			model.ModifiedAt = alias.ModifiedAt
+		}
+		result = append(result, model)
+	}
+	return result
+}
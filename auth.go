@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errModelNotAllowedForKey is returned for requests to models the client's API key
+// does not allow.
+var errModelNotAllowedForKey = errors.New("model is not allowed for this API key")
+
+// apiKeys are the keys clients have to send, from API_KEYS_FILE. nil leaves the
+// proxy open to everyone who can reach it.
+var apiKeys atomic.Pointer[apiKeyStore]
+
+// apiKey is an entry of the API keys file.
+type apiKey struct {
+	Name      string     `json:"name"`
+	Key       string     `json:"key,omitempty"`
+	KeySHA256 string     `json:"key_sha256,omitempty"` // Instead of the key itself
+	Models    []string   `json:"models,omitempty"`     // Rules like those of the models filter; empty allows all
+	Expires   *time.Time `json:"expires,omitempty"`
+	Enabled   *bool      `json:"enabled,omitempty"` // Default true
+	Admin     bool       `json:"admin,omitempty"`   // May read all usage and change the stored models
+
+	filter *ModelFilter
+}
+
+// apiKeyStore finds keys by the SHA-256 of the key.
+type apiKeyStore struct {
+	keys map[[sha256.Size]byte]*apiKey
+}
+
+// loadAPIKeys reads an API keys file, a JSON list of keys:
+//
+//	[{"name": "ci", "key": "...", "models": ["openai/*", "!*-preview"], "expires": "2026-12-31T00:00:00Z", "enabled": true, "admin": false}]
+func loadAPIKeys(path string) (*apiKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	store := &apiKeyStore{keys: make(map[[sha256.Size]byte]*apiKey, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if key == nil || key.Name == "" {
+			return nil, fmt.Errorf("%s: key %d needs a name", path, i+1)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("%s: duplicate key name %q", path, key.Name)
+		}
+		names[key.Name] = true
+
+		var hash [sha256.Size]byte
+		switch {
+		case key.Key != "" && key.KeySHA256 != "":
+			return nil, fmt.Errorf("%s: key %q has both key and key_sha256", path, key.Name)
+		case key.Key != "":
+			hash = sha256.Sum256([]byte(key.Key))
+		case key.KeySHA256 != "":
+			decoded, err := hex.DecodeString(key.KeySHA256)
+			if err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("%s: key %q has an invalid key_sha256", path, key.Name)
+			}
+			copy(hash[:], decoded)
+		default:
+			return nil, fmt.Errorf("%s: key %q needs a key or key_sha256", path, key.Name)
+		}
+		if _, duplicate := store.keys[hash]; duplicate {
+			return nil, fmt.Errorf("%s: key %q is the same as another key", path, key.Name)
+		}
+
+		if len(key.Models) > 0 {
+			// Attributes cannot be checked without catalog data, so such models are denied
+			key.filter = &ModelFilter{strict: true}
+			for _, line := range key.Models {
+				rule, err := parseFilterRule(strings.TrimSpace(line))
+				if err != nil {
+					return nil, fmt.Errorf("%s: key %q: %w", path, key.Name, err)
+				}
+				key.filter.rules = append(key.filter.rules, rule)
+			}
+		}
+		store.keys[hash] = key
+	}
+	return store, nil
+}
+
+// watchAPIKeys loads the API keys file and reloads it when it changes, so keys can
+// be added and revoked without a restart. Unlike the models filter, a file that
+// becomes invalid or is removed keeps the previous keys.
+func watchAPIKeys(path string) error {
+	modTime, size := statFile(path)
+	store, err := loadAPIKeys(path)
+	if err != nil {
+		return err
+	}
+	apiKeys.Store(store)
+	slog.Info("Loaded API keys, clients have to authenticate", "file", path, "keys", len(store.keys))
+
+	go pollFile(path, modTime, size, func(size int64) {
+		store, err := loadAPIKeys(path)
+		if err != nil {
+			slog.Error("Error reloading API keys, keeping the previous ones", "file", path, "Error", err)
+			return
+		}
+		apiKeys.Store(store)
+		slog.Info("Reloaded API keys", "file", path, "keys", len(store.keys))
+	})
+	return nil
+}
+
+// Authenticate returns the key a client sent, if it is valid.
+func (s *apiKeyStore) Authenticate(token string) (*apiKey, error) {
+	if token == "" {
+		return nil, errors.New("missing API key, send it as a bearer token or in the X-Api-Key header")
+	}
+	key, ok := s.keys[sha256.Sum256([]byte(token))]
+	switch {
+	case !ok:
+		return nil, errors.New("invalid API key")
+	case key.Enabled != nil && !*key.Enabled:
+		return nil, errors.New("API key is disabled")
+	case key.Expires != nil && time.Now().After(*key.Expires):
+		return nil, errors.New("API key has expired")
+	}
+	return key, nil
+}
+
+// Allows reports whether the key may use the model. nil is the key of a proxy
+// without authentication, which allows all models.
+func (k *apiKey) Allows(target filterTarget) bool {
+	return k == nil || k.filter.Allows(target)
+}
+
+// checkModel returns errModelNotAllowedForKey if the key may not use the model. The
+// model has to pass under the name it was requested by and by its ID alone, so an
+// alias whose name matches the key's patterns does not open up other models.
+func (k *apiKey) checkModel(target filterTarget) error {
+	if k.Allows(target) && k.Allows(filterTarget{name: target.id, id: target.id, meta: target.meta}) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errModelNotAllowedForKey, target.name)
+}
+
+// listed reports whether the key may use a model of the model lists.
+func (k *apiKey) listed(m Model) bool {
+	id := m.id
+	if id == "" {
+		id = m.Model
+	}
+	return k.Allows(filterTarget{name: m.Model, id: id, meta: m.meta})
+}
+
+// isAdmin reports whether the key may use the routes that affect all clients. nil
+// is the key of a proxy without authentication, where everyone may.
+func (k *apiKey) isAdmin() bool {
+	return k == nil || k.Admin
+}
+
+type apiKeyContextKey struct{}
+
+// requestKey returns the key the client authenticated with, nil without authentication.
+func requestKey(ctx context.Context) *apiKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*apiKey)
+	return key
+}
+
+// requestToken returns the API key a client sent, as a bearer token or in X-Api-Key.
+func requestToken(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return c.GetHeader("X-Api-Key")
+}
+
+// requireAPIKey is the middleware that authenticates clients once API keys are
+// configured. GET and HEAD of "/" stay open, as clients use them to check that the
+// server is up.
+func requireAPIKey(c *gin.Context) {
+	store := apiKeys.Load()
+	if store == nil || (c.Request.URL.Path == "/" && (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead)) {
+		c.Next()
+		return
+	}
+
+	key, err := store.Authenticate(requestToken(c))
+	if err != nil {
+		slog.Warn("Rejected request", "path", c.Request.URL.Path, "client", c.ClientIP(), "Error", err)
+		c.Header("WWW-Authenticate", "Bearer")
+		if strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+			openAIError(c, http.StatusUnauthorized, err.Error())
+		} else {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), apiKeyContextKey{}, key))
+	c.Next()
+}
+
+// requireAdmin is the middleware of the routes that affect all clients, the proxy's
+// own routes and changes to the stored models. They need an admin key once API keys
+// are configured.
+func requireAdmin(c *gin.Context) {
+	if requestKey(c.Request.Context()).isAdmin() {
+		c.Next()
+		return
+	}
+	slog.Warn("Rejected request of a key without admin rights", "path", c.Request.URL.Path, "key", requestKey(c.Request.Context()).Name)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this API key is not an admin key"})
+}
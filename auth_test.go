@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeAPIKeys writes an API keys file and returns its path.
+func writeAPIKeys(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	hashed := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: `[{"name": "a", "key": "k1", "models": ["openai/*", "@free"]}, {"name": "b", "key_sha256": "` + hashed + `"}]`},
+		{name: "invalid JSON", content: `{"name": "a"}`, wantErr: "failed to parse"},
+		{name: "missing name", content: `[{"key": "k1"}]`, wantErr: "needs a name"},
+		{name: "duplicate name", content: `[{"name": "a", "key": "k1"}, {"name": "a", "key": "k2"}]`, wantErr: "duplicate key name"},
+		{name: "missing key", content: `[{"name": "a"}]`, wantErr: "needs a key or key_sha256"},
+		{name: "key and hash", content: `[{"name": "a", "key": "k1", "key_sha256": "` + hashed + `"}]`, wantErr: "both key and key_sha256"},
+		{name: "invalid hash", content: `[{"name": "a", "key_sha256": "abc"}]`, wantErr: "invalid key_sha256"},
+		{name: "same key twice", content: `[{"name": "a", "key": "secret"}, {"name": "b", "key_sha256": "` + hashed + `"}]`, wantErr: "same as another key"},
+		{name: "invalid model rule", content: `[{"name": "a", "key": "k1", "models": ["@cheap"]}]`, wantErr: "unknown attribute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := loadAPIKeys(writeAPIKeys(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadAPIKeys() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadAPIKeys() error = %v", err)
+			}
+			if len(store.keys) != 2 {
+				t.Errorf("loadAPIKeys() has %d keys, want 2", len(store.keys))
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	sum := sha256.Sum256([]byte("k-hashed"))
+	store, err := loadAPIKeys(writeAPIKeys(t, `[
+		{"name": "plain", "key": "k-plain"},
+		{"name": "hashed", "key_sha256": "`+hex.EncodeToString(sum[:])+`"},
+		{"name": "enabled", "key": "k-enabled", "enabled": true, "expires": "`+future+`"},
+		{"name": "disabled", "key": "k-disabled", "enabled": false},
+		{"name": "expired", "key": "k-expired", "expires": "`+past+`"}
+	]`))
+	if err != nil {
+		t.Fatalf("loadAPIKeys() error = %v", err)
+	}
+
+	tests := []struct {
+		token    string
+		wantName string
+		wantErr  string
+	}{
+		{token: "k-plain", wantName: "plain"},
+		{token: "k-hashed", wantName: "hashed"},
+		{token: "k-enabled", wantName: "enabled"},
+		{token: "k-disabled", wantErr: "disabled"},
+		{token: "k-expired", wantErr: "expired"},
+		{token: "k-unknown", wantErr: "invalid API key"},
+		{token: "", wantErr: "missing API key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			key, err := store.Authenticate(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if key.Name != tt.wantName {
+				t.Errorf("Authenticate() = %q, want %q", key.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestAPIKeyCheckModel(t *testing.T) {
+	store, err := loadAPIKeys(writeAPIKeys(t, `[
+		{"name": "gpt", "key": "k-gpt", "models": ["*gpt*", "!*-preview"]},
+		{"name": "free", "key": "k-free", "models": ["@free"]}
+	]`))
+	if err != nil {
+		t.Fatalf("loadAPIKeys() error = %v", err)
+	}
+	gpt, _ := store.Authenticate("k-gpt")
+	free, _ := store.Authenticate("k-free")
+	freeModel := &openrouterModel{ID: "deepseek/deepseek-chat:free"}
+
+	tests := []struct {
+		name   string
+		key    *apiKey
+		target filterTarget
+		want   bool
+	}{
+		{name: "model", key: gpt, target: filterTarget{name: "gpt-4o", id: "openai/gpt-4o"}, want: true},
+		{name: "denied model", key: gpt, target: filterTarget{name: "gpt-4o-preview", id: "openai/gpt-4o-preview"}, want: false},
+		{name: "alias of an allowed model", key: gpt, target: filterTarget{name: "fast", id: "openai/gpt-4o"}, want: true},
+		{name: "alias of another model", key: gpt, target: filterTarget{name: "my-gpt", id: "anthropic/claude-3.5-haiku"}, want: false},
+		{name: "free model", key: free, target: filterTarget{name: "deepseek-chat:free", id: freeModel.ID, meta: freeModel}, want: true},
+		{name: "model without catalog data", key: free, target: filterTarget{name: "llama3", id: "llama3"}, want: false},
+		{name: "without authentication", key: nil, target: filterTarget{name: "llama3", id: "llama3"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.checkModel(tt.target)
+			if got := err == nil; got != tt.want {
+				t.Errorf("checkModel() error = %v, want allowed %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := loadAPIKeys(writeAPIKeys(t, `[{"name": "user", "key": "k-user"}, {"name": "boss", "key": "k-admin", "admin": true}]`))
+	if err != nil {
+		t.Fatalf("loadAPIKeys() error = %v", err)
+	}
+	saved := apiKeys.Load()
+	defer apiKeys.Store(saved)
+	apiKeys.Store(store)
+
+	r := gin.New()
+	r.Use(requireAPIKey)
+	keyName := func(c *gin.Context) {
+		name := ""
+		if key := requestKey(c.Request.Context()); key != nil {
+			name = key.Name
+		}
+		c.String(http.StatusOK, name)
+	}
+	r.GET("/", keyName)
+	r.HEAD("/", keyName)
+	r.GET("/api/tags", keyName)
+	r.GET("/v1/models", keyName)
+	r.GET("/proxy/usage", requireAdmin, keyName)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "health check", method: http.MethodGet, path: "/", wantStatus: http.StatusOK},
+		{name: "head health check", method: http.MethodHead, path: "/", wantStatus: http.StatusOK},
+		{name: "missing key", method: http.MethodGet, path: "/api/tags", wantStatus: http.StatusUnauthorized, wantBody: `{"error":"missing API key, send it as a bearer token or in the X-Api-Key header"}`},
+		{name: "invalid key", method: http.MethodGet, path: "/api/tags", header: "Authorization", value: "Bearer nope", wantStatus: http.StatusUnauthorized, wantBody: `{"error":"invalid API key"}`},
+		{name: "bearer token", method: http.MethodGet, path: "/api/tags", header: "Authorization", value: "Bearer k-user", wantStatus: http.StatusOK, wantBody: "user"},
+		{name: "X-Api-Key", method: http.MethodGet, path: "/api/tags", header: "X-Api-Key", value: "k-user", wantStatus: http.StatusOK, wantBody: "user"},
+		{name: "OpenAI error format", method: http.MethodGet, path: "/v1/models", wantStatus: http.StatusUnauthorized, wantBody: `{"error":{"code":null,"message":"missing API key, send it as a bearer token or in the X-Api-Key header","type":"authentication_error"}}`},
+		{name: "admin route", method: http.MethodGet, path: "/proxy/usage", header: "X-Api-Key", value: "k-admin", wantStatus: http.StatusOK, wantBody: "boss"},
+		{name: "admin route without admin key", method: http.MethodGet, path: "/proxy/usage", header: "X-Api-Key", value: "k-user", wantStatus: http.StatusForbidden, wantBody: `{"error":"this API key is not an admin key"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("401 responses should ask for a bearer token")
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			got := w.Body.String()
+			if strings.HasPrefix(tt.wantBody, "{") {
+				// Compare JSON independent of the key order
+				var gotJSON, wantJSON interface{}
+				json.Unmarshal([]byte(got), &gotJSON)
+				json.Unmarshal([]byte(tt.wantBody), &wantJSON)
+				gotBytes, _ := json.Marshal(gotJSON)
+				wantBytes, _ := json.Marshal(wantJSON)
+				got, tt.wantBody = string(gotBytes), string(wantBytes)
+			}
+			if got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+
+	// Without API keys, the proxy is open and every client is an admin
+	apiKeys.Store(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxy/usage", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status without API keys = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Provider is a model backend the Ollama-compatible API can be served from.
+// Requests and responses use the OpenAI chat types, with the model's reasoning
+// next to them; each implementation translates them to its upstream API.
+type Provider interface {
+	Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error)
+	ChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatCompletionStream, error)
+	Generate(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatResponse, error)
+	GenerateStream(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatCompletionStream, error)
+	GetModels(ctx context.Context) ([]Model, error)
+	GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error)
+	GetFullModelName(ctx context.Context, alias string) (string, error)
+}
+
+// ChatResponse is a complete chat response with the model's reasoning, which
+// go-openai has no field for.
+type ChatResponse struct {
+	openai.ChatCompletionResponse
+	Reasoning string `json:"-"` // Of the first choice
+}
+
+// ChatChunk is a chunk of a chat stream with the model's reasoning delta.
+type ChatChunk struct {
+	openai.ChatCompletionStreamResponse
+	Reasoning string `json:"-"` // Of the first choice
+}
+
+// embeddingProvider is implemented by providers whose upstream can create embeddings.
+// With truncate, inputs longer than the model's context are shortened instead of failing.
+type embeddingProvider interface {
+	CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error)
+}
+
+var errEmbeddingsNotSupported = errors.New("embeddings are not supported by the backend of this model")
+
+// createEmbeddings creates embeddings with the provider, if it supports them.
+func createEmbeddings(ctx context.Context, provider Provider, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error) {
+	embedder, ok := provider.(embeddingProvider)
+	if !ok {
+		return openai.EmbeddingResponse{}, errEmbeddingsNotSupported
+	}
+	return embedder.CreateEmbeddings(ctx, req, truncate)
+}
+
+// completionProvider is implemented by providers whose upstream has a text completions
+// endpoint. The prompt is sent as it is, without a chat template, for raw prompts and
+// fill-in-the-middle.
+type completionProvider interface {
+	Complete(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatResponse, error)
+	CompleteStream(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatCompletionStream, error)
+}
+
+var errCompletionsNotSupported = errors.New("raw prompts, templates and suffixes are not supported by the backend of this model")
+
+// complete runs a text completion with the provider, if it supports them.
+func complete(ctx context.Context, provider Provider, prompt string, suffix string, modelName string, opts RequestOptions) (ChatResponse, error) {
+	completer, ok := provider.(completionProvider)
+	if !ok {
+		return ChatResponse{}, errCompletionsNotSupported
+	}
+	return completer.Complete(ctx, prompt, suffix, modelName, opts)
+}
+
+// completeStream is complete for streaming requests.
+func completeStream(ctx context.Context, provider Provider, prompt string, suffix string, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	completer, ok := provider.(completionProvider)
+	if !ok {
+		return nil, errCompletionsNotSupported
+	}
+	return completer.CompleteStream(ctx, prompt, suffix, modelName, opts)
+}
+
+// Supported backend types
+const (
+	BackendOpenrouter = "openrouter"
+	BackendOpenAI     = "openai"
+	BackendAnthropic  = "anthropic"
+	BackendOllama     = "ollama"
+)
+
+// BackendConfig describes one upstream the proxy can send requests to.
+type BackendConfig struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	BaseURL string `json:"base_url,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+	// Environment variable holding the API key, to keep secrets out of config files
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+	// OpenRouter provider routing preferences for every request, OPENROUTER_PROVIDER if unset
+	Provider map[string]interface{} `json:"provider,omitempty"`
+}
+
+// backendConfigFromEnv reads the backend selected with the PROVIDER environment variable.
+// The OpenRouter key keeps coming from OPENAI_API_KEY or the command line.
+func backendConfigFromEnv(apiKey string) BackendConfig {
+	backendType := strings.ToLower(os.Getenv("PROVIDER"))
+	if backendType == "" {
+		backendType = BackendOpenrouter
+	}
+
+	config := BackendConfig{Name: backendType, Type: backendType}
+	switch backendType {
+	case BackendOpenrouter:
+		config.APIKey = apiKey
+	case BackendOpenAI:
+		config.BaseURL = os.Getenv("OPENAI_BASE_URL")
+		config.APIKey = apiKey
+	case BackendAnthropic:
+		config.BaseURL = os.Getenv("ANTHROPIC_BASE_URL")
+		config.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+	case BackendOllama:
+		config.BaseURL = os.Getenv("OLLAMA_BASE_URL")
+	}
+	return config
+}
+
+// NewProvider creates the provider for a backend configuration.
+func NewProvider(config BackendConfig) (Provider, error) {
+	if config.APIKey == "" && config.APIKeyEnv != "" {
+		config.APIKey = os.Getenv(config.APIKeyEnv)
+	}
+
+	switch config.Type {
+	case BackendOpenrouter:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY environment variable or command-line argument not set")
+		}
+		preferences := config.Provider
+		if preferences == nil {
+			preferences = providerPreferences
+		} else if err := validateProviderPreferences(preferences); err != nil {
+			return nil, fmt.Errorf("backend %q: %w", config.Name, err)
+		}
+		provider := NewOpenrouterProvider(config.APIKey)
+		provider.preferences = preferences
+		return provider, nil
+	case BackendOpenAI:
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("backend %q: base URL of the OpenAI-compatible API not set", config.Name)
+		}
+		return NewOpenAIProvider(config.BaseURL, config.APIKey), nil
+	case BackendAnthropic:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("backend %q: Anthropic API key not set", config.Name)
+		}
+		return NewAnthropicProvider(config.BaseURL, config.APIKey), nil
+	case BackendOllama:
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("backend %q: Ollama base URL not set", config.Name)
+		}
+		return NewOllamaProvider(config.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("backend %q: unknown type %q", config.Name, config.Type)
+	}
+}
+
+// upstreamHTTPError converts an unsuccessful upstream response into an API error,
+// the same error type go-openai returns, so callers can inspect the status code.
+func upstreamHTTPError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = resp.Status
+	}
+	return &openai.APIError{
+		HTTPStatusCode: resp.StatusCode,
+		Message:        message,
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCatalogTTL = 10 * time.Minute
+	// Limit for a single catalog fetch, which runs detached from client requests
+	catalogFetchTimeout = 30 * time.Second
+	// Minimum time between refreshes triggered by requests while the upstream fails
+	catalogRetryInterval = 30 * time.Second
+)
+
+// loadCatalogTTL reads how long the model catalog is fresh from MODELS_CACHE_TTL.
+func loadCatalogTTL() (time.Duration, error) {
+	value := os.Getenv("MODELS_CACHE_TTL")
+	if value == "" {
+		return defaultCatalogTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, errors.New("TTL must be positive")
+	}
+	return ttl, nil
+}
+
+// catalogSnapshot is an immutable copy of the model catalog, safe to share between requests.
+type catalogSnapshot struct {
+	models    []openrouterModel
+	ids       []string
+	byID      map[string]*openrouterModel
+	fetchedAt time.Time
+}
+
+func newCatalogSnapshot(models []openrouterModel) *catalogSnapshot {
+	snapshot := &catalogSnapshot{
+		models:    models,
+		ids:       make([]string, len(models)),
+		byID:      make(map[string]*openrouterModel, len(models)),
+		fetchedAt: time.Now(),
+	}
+	for i := range models {
+		snapshot.ids[i] = models[i].ID
+		snapshot.byID[models[i].ID] = &models[i]
+	}
+	return snapshot
+}
+
+// catalogRefresh is a fetch in progress; concurrent refreshes share it.
+type catalogRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// modelCatalog caches the upstream model catalog. It is refreshed in the background
+// every TTL; requests are served from the cache and only wait for the catalog before
+// the first fetch succeeded. When a refresh fails, the previous catalog is kept.
+type modelCatalog struct {
+	fetch func(ctx context.Context) ([]openrouterModel, error)
+	ttl   time.Duration
+
+	snapshot atomic.Pointer[catalogSnapshot]
+
+	mu          sync.Mutex
+	inflight    *catalogRefresh
+	lastAttempt time.Time
+}
+
+func newModelCatalog(fetch func(ctx context.Context) ([]openrouterModel, error), ttl time.Duration) *modelCatalog {
+	return &modelCatalog{fetch: fetch, ttl: ttl}
+}
+
+// Start loads the catalog and keeps refreshing it every TTL.
+func (c *modelCatalog) Start() {
+	c.startRefresh()
+	go func() {
+		ticker := time.NewTicker(c.ttl)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.startRefresh()
+		}
+	}()
+}
+
+// startRefresh fetches the catalog in the background, unless a fetch is already running.
+func (c *modelCatalog) startRefresh() *catalogRefresh {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight != nil {
+		return c.inflight
+	}
+
+	refresh := &catalogRefresh{done: make(chan struct{})}
+	c.inflight = refresh
+	c.lastAttempt = time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), catalogFetchTimeout)
+		defer cancel()
+
+		models, err := c.fetch(ctx)
+		if err != nil {
+			if c.snapshot.Load() != nil {
+				slog.Warn("Failed to refresh model catalog, serving the cached one", "Error", err)
+			} else {
+				slog.Error("Failed to load model catalog", "Error", err)
+			}
+			refresh.err = err
+		} else {
+			c.snapshot.Store(newCatalogSnapshot(models))
+			slog.Info("Model catalog refreshed", "models", len(models))
+		}
+
+		c.mu.Lock()
+		c.inflight = nil
+		c.mu.Unlock()
+		close(refresh.done)
+	}()
+	return refresh
+}
+
+// Refresh fetches the catalog now and waits for the result.
+func (c *modelCatalog) Refresh(ctx context.Context) error {
+	refresh := c.startRefresh()
+	select {
+	case <-refresh.done:
+		return refresh.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get returns the cached catalog. A stale catalog is returned right away while it
+// is revalidated in the background.
+func (c *modelCatalog) Get(ctx context.Context) (*catalogSnapshot, error) {
+	snapshot := c.snapshot.Load()
+	if snapshot == nil {
+		// Nothing to serve before the first successful fetch
+		if err := c.Refresh(ctx); err != nil {
+			return nil, fmt.Errorf("model catalog not available: %w", err)
+		}
+		return c.snapshot.Load(), nil
+	}
+
+	if time.Since(snapshot.fetchedAt) > c.ttl {
+		c.mu.Lock()
+		retry := time.Since(c.lastAttempt) > catalogRetryInterval
+		c.mu.Unlock()
+		if retry {
+			c.startRefresh()
+		}
+	}
+	return snapshot, nil
+}
+
+// modelRefresher is implemented by providers that cache their model list.
+type modelRefresher interface {
+	RefreshModels(ctx context.Context) error
+}
+
+// refreshModels makes the provider fetch its model list again, if it caches it.
+func refreshModels(ctx context.Context, provider Provider) error {
+	refresher, ok := provider.(modelRefresher)
+	if !ok {
+		return nil
+	}
+	return refresher.RefreshModels(ctx)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Maximum embedding input length in tokens, used to truncate inputs for
+// OpenAI-style upstreams, which fail on longer inputs instead
+var embeddingMaxTokens = 8192
+
+// Characters per token used to estimate the input length. It errs on the short
+// side for English text, as tokens are not counted exactly.
+const embeddingCharsPerToken = 3
+
+// truncateEmbeddingInput shortens string inputs to the estimated maximum input length.
+// Token arrays are passed on unchanged.
+func truncateEmbeddingInput(input interface{}) interface{} {
+	switch v := input.(type) {
+	case string:
+		return truncateEmbeddingText(v)
+	case []string:
+		truncated := make([]string, len(v))
+		for i, text := range v {
+			truncated[i] = truncateEmbeddingText(text)
+		}
+		return truncated
+	}
+	return input
+}
+
+func truncateEmbeddingText(text string) string {
+	maxBytes := embeddingMaxTokens * embeddingCharsPerToken
+	if len(text) <= maxBytes {
+		return text
+	}
+	// Do not cut a multi-byte character in half
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	slog.Warn("Truncating embedding input", "length", len(text), "maxLength", end)
+	return text[:end]
+}
+
+// EmbeddingService creates embeddings for the Ollama and OpenAI APIs. It uses the
+// dedicated embeddings upstream if one is configured and the chat backend otherwise.
+type EmbeddingService struct {
+	provider Provider
+	models   []string // Embedding models listed in /api/tags and /v1/models
+}
+
+// loadEmbeddingService reads the embeddings upstream from the environment:
+// EMBEDDINGS_BASE_URL and EMBEDDINGS_API_KEY select an OpenAI-style upstream,
+// EMBEDDINGS_MODELS lists its models and EMBEDDINGS_MAX_TOKENS limits the input length.
+func loadEmbeddingService(chat Provider, apiKey string, retries int, backoff time.Duration) (*EmbeddingService, error) {
+	if value := os.Getenv("EMBEDDINGS_MAX_TOKENS"); value != "" {
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens <= 0 {
+			return nil, fmt.Errorf("invalid EMBEDDINGS_MAX_TOKENS %q", value)
+		}
+		embeddingMaxTokens = maxTokens
+	}
+
+	service := &EmbeddingService{provider: chat}
+	if baseURL := os.Getenv("EMBEDDINGS_BASE_URL"); baseURL != "" {
+		key := os.Getenv("EMBEDDINGS_API_KEY")
+		if key == "" {
+			key = apiKey
+		}
+		slog.Info("Using dedicated embeddings upstream", "baseURL", baseURL)
+		service.provider = NewFailoverProvider(NewOpenAIProvider(baseURL, key), nil, retries, backoff)
+	}
+
+	for _, model := range strings.Split(os.Getenv("EMBEDDINGS_MODELS"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			service.models = append(service.models, model)
+		}
+	}
+	return service, nil
+}
+
+// ResolveModel returns the full name of an embedding model, which the client's API
+// key and, unless it is an alias, the models filter have to allow.
+func (e *EmbeddingService) ResolveModel(ctx context.Context, model string) (string, error) {
+	fullName, alias, err := resolveModelAlias(ctx, e.provider, model)
+	if err != nil {
+		return "", err
+	}
+	target := filterTarget{name: model, id: fullName, meta: catalogModel(ctx, e.provider, fullName)}
+	if err := requestKey(ctx).checkModel(target); err != nil {
+		return "", err
+	}
+	if alias == nil && !modelFilter.Load().Allows(target) {
+		return "", fmt.Errorf("%w: %s", errModelNotAllowed, model)
+	}
+	return fullName, nil
+}
+
+// Create creates embeddings of the inputs with a resolved model.
+func (e *EmbeddingService) Create(ctx context.Context, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error) {
+	return createEmbeddings(ctx, e.provider, req, truncate)
+}
+
+// Models returns the configured embedding models for the model lists.
+func (e *EmbeddingService) Models() []Model {
+	currentTime := time.Now().Format(time.RFC3339)
+	models := make([]Model, 0, len(e.models))
+	for _, name := range e.models {
+		models = append(models, Model{
+			Name:       name,
+			Model:      name,
+			ModifiedAt: currentTime,
+			Digest:     name,
+			Details: ModelDetails{
+				Format:   "api",
+				Family:   "embedding",
+				Families: []string{"embedding"},
+			},
+		})
+	}
+	return models
+}
+
+// embeddingInputs returns the texts of an Ollama embed input, a string or a list of strings.
+func embeddingInputs(input interface{}) ([]string, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []interface{}:
+		inputs := make([]string, 0, len(v))
+		for _, item := range v {
+			text, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("input must be a string or a list of strings, got %T item", item)
+			}
+			inputs = append(inputs, text)
+		}
+		return inputs, nil
+	default:
+		return nil, fmt.Errorf("input must be a string or a list of strings, got %T", input)
+	}
+}
+
+// embed resolves the model and creates embeddings of the inputs, in input order.
+// On failure it answers the client itself and returns false.
+func (e *EmbeddingService) embed(c *gin.Context, model string, inputs []string, dimensions int, truncate bool) ([][]float32, openai.Usage, bool) {
+	ctx, cancel := upstreamContext(c)
+	defer cancel()
+
+	slog.Info("Requested embedding model", "model", model)
+	fullModelName, err := e.ResolveModel(ctx, model)
+	if err != nil {
+		slog.Error("Error getting full model name", "Error", err, "model", model)
+		c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+		return nil, openai.Usage{}, false
+	}
+
+	response, err := e.Create(ctx, openai.EmbeddingRequest{
+		Input:          inputs,
+		Model:          openai.EmbeddingModel(fullModelName),
+		EncodingFormat: openai.EmbeddingEncodingFormatFloat,
+		Dimensions:     dimensions,
+	}, truncate)
+	if err != nil {
+		handleUpstreamError(c, "Failed to create embeddings", err)
+		return nil, openai.Usage{}, false
+	}
+	if len(response.Data) != len(inputs) {
+		err := fmt.Errorf("expected %d embeddings from upstream, got %d", len(inputs), len(response.Data))
+		handleUpstreamError(c, "Failed to create embeddings", err)
+		return nil, openai.Usage{}, false
+	}
+
+	sort.Slice(response.Data, func(i, j int) bool {
+		return response.Data[i].Index < response.Data[j].Index
+	})
+	embeddings := make([][]float32, len(response.Data))
+	for i, embedding := range response.Data {
+		embeddings[i] = embedding.Embedding
+	}
+	return embeddings, response.Usage, true
+}
+
+// registerEmbeddingRoutes adds Ollama's /api/embed and the legacy /api/embeddings.
+func registerEmbeddingRoutes(r *gin.Engine, embeddings *EmbeddingService) {
+	r.POST("/api/embed", func(c *gin.Context) {
+		start := time.Now()
+
+		var request struct {
+			Model      string                 `json:"model"`
+			Input      interface{}            `json:"input"`
+			Truncate   *bool                  `json:"truncate"`
+			Dimensions int                    `json:"dimensions"`
+			Options    map[string]interface{} `json:"options"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		if request.Model == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+			return
+		}
+		inputs, err := embeddingInputs(request.Input)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(request.Options) > 0 {
+			slog.Warn("Options are not supported for embeddings, ignoring them", "model", request.Model)
+		}
+
+		// Like Ollama, an empty input returns no embeddings without loading the model
+		if len(inputs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"model": request.Model, "embeddings": [][]float32{}})
+			return
+		}
+
+		// Ollama truncates by default
+		truncate := request.Truncate == nil || *request.Truncate
+		vectors, usage, ok := embeddings.embed(c, request.Model, inputs, request.Dimensions, truncate)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"model":             request.Model,
+			"embeddings":        vectors,
+			"total_duration":    time.Since(start).Nanoseconds(),
+			"load_duration":     0,
+			"prompt_eval_count": usage.PromptTokens,
+		})
+	})
+
+	r.POST("/api/embeddings", func(c *gin.Context) {
+		var request struct {
+			Model      string                 `json:"model"`
+			Prompt     string                 `json:"prompt"`
+			Truncate   *bool                  `json:"truncate"`
+			Dimensions int                    `json:"dimensions"`
+			Options    map[string]interface{} `json:"options"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		if request.Model == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+			return
+		}
+		if len(request.Options) > 0 {
+			slog.Warn("Options are not supported for embeddings, ignoring them", "model", request.Model)
+		}
+		if request.Prompt == "" {
+			c.JSON(http.StatusOK, gin.H{"embedding": []float32{}})
+			return
+		}
+
+		truncate := request.Truncate == nil || *request.Truncate
+		vectors, _, ok := embeddings.embed(c, request.Model, []string{request.Prompt}, request.Dimensions, truncate)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"embedding": vectors[0]})
+	})
+}
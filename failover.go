@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const maxRetryBackoff = 10 * time.Second
+
+// nativeFallbackProvider is implemented by providers whose upstream can fall back
+// between models itself, like OpenRouter with its "models" parameter.
+type nativeFallbackProvider interface {
+	// SupportsNativeFallback reports whether the first model can be requested with
+	// the others as fallbacks in a single upstream request
+	SupportsNativeFallback(models []string) bool
+}
+
+// loadFallbacks reads fallback chains, one per line: "deepseek-chat -> qwen-2.5-72b -> gpt-4o-mini".
+// Empty lines and lines starting with # are ignored.
+func loadFallbacks(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fallbacks := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var chain []string
+		for _, model := range strings.Split(strings.ReplaceAll(line, "→", "->"), "->") {
+			if model = strings.TrimSpace(model); model != "" {
+				chain = append(chain, model)
+			}
+		}
+		if len(chain) < 2 {
+			return nil, fmt.Errorf("line %d: expected at least one fallback model", lineNumber)
+		}
+		fallbacks[chain[0]] = chain[1:]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fallbacks, nil
+}
+
+// FailoverProvider retries failed upstream requests with exponential backoff and
+// falls back to other models. Failover only happens before anything has been sent
+// to the client; an error in the middle of a stream is passed on.
+type FailoverProvider struct {
+	inner     Provider
+	fallbacks map[string][]string // model -> fallback models, in order
+	retries   int                 // Retries per model for retryable errors
+	backoff   time.Duration       // Initial backoff, doubled for every retry
+
+	// Aliases handed to GetFullModelName, so chains configured for an alias
+	// also apply to the full name the handlers use afterwards
+	aliases sync.Map // full name -> alias
+}
+
+func NewFailoverProvider(inner Provider, fallbacks map[string][]string, retries int, backoff time.Duration) *FailoverProvider {
+	return &FailoverProvider{
+		inner:     inner,
+		fallbacks: fallbacks,
+		retries:   retries,
+		backoff:   backoff,
+	}
+}
+
+// loadRetrySettings reads UPSTREAM_RETRIES and UPSTREAM_RETRY_BACKOFF from the environment.
+func loadRetrySettings() (int, time.Duration, error) {
+	retries, backoff := 2, 500*time.Millisecond
+	if value := os.Getenv("UPSTREAM_RETRIES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("invalid UPSTREAM_RETRIES %q", value)
+		}
+		retries = parsed
+	}
+	if value := os.Getenv("UPSTREAM_RETRY_BACKOFF"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("invalid UPSTREAM_RETRY_BACKOFF %q", value)
+		}
+		backoff = parsed
+	}
+	return retries, backoff, nil
+}
+
+// upstreamStatusCode returns the HTTP status of an upstream error, or 0 if there was none.
+func upstreamStatusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// isNetworkError reports whether an error is a failure of the connection to the
+// upstream, e.g. refused, reset, timed out or closed in the middle of a response.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isRetryable reports whether an upstream error is worth retrying: rate limits,
+// server errors and network errors. Cancellations, client errors and errors of the
+// proxy itself, like a backend without completions, are final.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errCompletionsNotSupported) || errors.Is(err, errEmbeddingsNotSupported) {
+		return false
+	}
+	switch status := upstreamStatusCode(err); {
+	case status == 0:
+		// No HTTP response; only network failures may go away
+		return isNetworkError(err)
+	case status == http.StatusTooManyRequests, status == http.StatusRequestTimeout, status >= 500:
+		return true
+	}
+	return false
+}
+
+// shouldFallback reports whether another model may succeed where this one failed.
+func shouldFallback(err error) bool {
+	status := upstreamStatusCode(err)
+	return isRetryable(err) || status == http.StatusNotFound || status == http.StatusPaymentRequired
+}
+
+// chain returns the models to try for a request, starting with the requested one.
+func (f *FailoverProvider) chain(ctx context.Context, modelName string) []string {
+	fallbacks, ok := f.fallbacks[modelName]
+	if !ok {
+		if alias, found := f.aliases.Load(modelName); found {
+			fallbacks = f.fallbacks[alias.(string)]
+		}
+	}
+
+	models := []string{modelName}
+	for _, fallback := range fallbacks {
+		fullName, err := resolveModel(ctx, f.inner, fallback)
+		if err != nil {
+			slog.Warn("Skipping unknown fallback model", "model", fallback, "Error", err)
+			continue
+		}
+		models = append(models, fullName)
+	}
+	return models
+}
+
+// run calls attempt for each model of the chain until one succeeds, retrying
+// retryable errors with backoff before moving on to the next model.
+func (f *FailoverProvider) run(ctx context.Context, modelName string, opts RequestOptions, attempt func(model string, opts RequestOptions) error) error {
+	models := f.chain(ctx, modelName)
+
+	var lastErr error
+	for i, model := range models {
+		modelOpts := opts
+		if native, ok := f.inner.(nativeFallbackProvider); ok && i+1 < len(models) && native.SupportsNativeFallback(models[i:]) {
+			// Let the upstream try the remaining models as well before giving up on this one
+			modelOpts.Fallbacks = models[i+1:]
+		}
+
+		for try := 0; try <= f.retries; try++ {
+			if try > 0 {
+				if err := sleepBackoff(ctx, f.backoff, try); err != nil {
+					return err
+				}
+			}
+
+			err := attempt(model, modelOpts)
+			if err == nil {
+				if model != modelName {
+					slog.Info("Request served by fallback model", "requestedModel", modelName, "model", model)
+				}
+				return nil
+			}
+			lastErr = err
+			if !isRetryable(err) || try == f.retries {
+				break
+			}
+			slog.Warn("Upstream request failed, retrying", "model", model, "attempt", try+1, "Error", err)
+		}
+
+		if !shouldFallback(lastErr) {
+			return lastErr
+		}
+		if i+1 < len(models) {
+			slog.Warn("Model failed, falling back", "model", model, "fallback", models[i+1], "Error", lastErr)
+		}
+	}
+	return lastErr
+}
+
+// sleepBackoff waits before a retry using exponential backoff with full jitter.
+func sleepBackoff(ctx context.Context, base time.Duration, try int) error {
+	backoff := base << (try - 1)
+	if backoff > maxRetryBackoff || backoff <= 0 {
+		backoff = maxRetryBackoff
+	}
+	delay := time.Duration(rand.Int63n(int64(backoff)) + 1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// servedModel returns the model to report to the client. OpenRouter names the model that
+// actually answered when it fell back natively; otherwise it is the model that was called.
+func servedModel(calledModel string, upstreamModel string, opts RequestOptions) string {
+	if len(opts.Fallbacks) > 0 && upstreamModel != "" {
+		return upstreamModel
+	}
+	return calledModel
+}
+
+func (f *FailoverProvider) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error) {
+	var resp ChatResponse
+	err := f.run(ctx, modelName, opts, func(model string, opts RequestOptions) error {
+		var err error
+		resp, err = f.inner.Chat(ctx, messages, model, opts)
+		resp.Model = servedModel(model, resp.Model, opts)
+		return err
+	})
+	return resp, err
+}
+
+func (f *FailoverProvider) ChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	var stream ChatCompletionStream
+	err := f.run(ctx, modelName, opts, func(model string, opts RequestOptions) error {
+		var err error
+		stream, err = peekStream(f.inner.ChatStream(ctx, messages, model, opts))
+		if err == nil {
+			stream = &modelStream{ChatCompletionStream: stream, model: model, opts: opts}
+		}
+		return err
+	})
+	return stream, err
+}
+
+func (f *FailoverProvider) Generate(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatResponse, error) {
+	var resp ChatResponse
+	err := f.run(ctx, modelName, opts, func(model string, opts RequestOptions) error {
+		var err error
+		resp, err = f.inner.Generate(ctx, prompt, model, systemPrompt, images, opts)
+		resp.Model = servedModel(model, resp.Model, opts)
+		return err
+	})
+	return resp, err
+}
+
+func (f *FailoverProvider) GenerateStream(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatCompletionStream, error) {
+	var stream ChatCompletionStream
+	err := f.run(ctx, modelName, opts, func(model string, opts RequestOptions) error {
+		var err error
+		stream, err = peekStream(f.inner.GenerateStream(ctx, prompt, model, systemPrompt, images, opts))
+		if err == nil {
+			stream = &modelStream{ChatCompletionStream: stream, model: model, opts: opts}
+		}
+		return err
+	})
+	return stream, err
+}
+
+// Complete retries temporary errors but never falls back to another model, as raw
+// prompts are written for the model they are sent to.
+func (f *FailoverProvider) Complete(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatResponse, error) {
+	var resp ChatResponse
+	err := f.retry(ctx, modelName, func() error {
+		var err error
+		resp, err = complete(ctx, f.inner, prompt, suffix, modelName, opts)
+		return err
+	})
+	return resp, err
+}
+
+// CompleteStream is Complete for streaming requests.
+func (f *FailoverProvider) CompleteStream(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	var stream ChatCompletionStream
+	err := f.retry(ctx, modelName, func() error {
+		var err error
+		stream, err = peekStream(completeStream(ctx, f.inner, prompt, suffix, modelName, opts))
+		return err
+	})
+	return stream, err
+}
+
+// retry calls attempt until it succeeds, retrying retryable errors with backoff.
+func (f *FailoverProvider) retry(ctx context.Context, modelName string, attempt func() error) error {
+	var err error
+	for try := 0; try <= f.retries; try++ {
+		if try > 0 {
+			if err := sleepBackoff(ctx, f.backoff, try); err != nil {
+				return err
+			}
+		}
+		err = attempt()
+		if err == nil || !isRetryable(err) || try == f.retries {
+			break
+		}
+		slog.Warn("Upstream request failed, retrying", "model", modelName, "attempt", try+1, "Error", err)
+	}
+	return err
+}
+
+// CreateEmbeddings retries temporary errors but never falls back to another model,
+// as embeddings of different models are not comparable.
+func (f *FailoverProvider) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error) {
+	var resp openai.EmbeddingResponse
+	err := f.retry(ctx, string(req.Model), func() error {
+		var err error
+		resp, err = createEmbeddings(ctx, f.inner, req, truncate)
+		return err
+	})
+	return resp, err
+}
+
+func (f *FailoverProvider) GetModels(ctx context.Context) ([]Model, error) {
+	return f.inner.GetModels(ctx)
+}
+
+func (f *FailoverProvider) RefreshModels(ctx context.Context) error {
+	return refreshModels(ctx, f.inner)
+}
+
+func (f *FailoverProvider) ModelIDs(ctx context.Context) ([]string, error) {
+	lister, ok := f.inner.(modelIDLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.ModelIDs(ctx)
+}
+
+func (f *FailoverProvider) CatalogModel(ctx context.Context, id string) *openrouterModel {
+	return catalogModel(ctx, f.inner, id)
+}
+
+func (f *FailoverProvider) GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error) {
+	return f.inner.GetModelDetails(ctx, modelName)
+}
+
+func (f *FailoverProvider) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	fullName, err := f.inner.GetFullModelName(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := f.fallbacks[alias]; ok {
+		f.aliases.Store(fullName, alias)
+	}
+	return fullName, nil
+}
+
+// peekStream reads the first chunk of a new stream, so that errors the upstream only
+// reports inside the stream still happen before anything is sent to the client.
+func peekStream(stream ChatCompletionStream, err error) (ChatCompletionStream, error) {
+	if err != nil {
+		return nil, err
+	}
+	first, err := stream.Recv()
+	if err != nil && !errors.Is(err, io.EOF) {
+		stream.Close()
+		return nil, err
+	}
+	return &peekedStream{ChatCompletionStream: stream, first: &first, firstErr: err}, nil
+}
+
+type peekedStream struct {
+	ChatCompletionStream
+	first    *ChatChunk
+	firstErr error
+}
+
+func (s *peekedStream) Recv() (ChatChunk, error) {
+	if s.first != nil {
+		first := *s.first
+		s.first = nil
+		return first, s.firstErr
+	}
+	return s.ChatCompletionStream.Recv()
+}
+
+// modelStream reports the model that served the stream in every chunk.
+type modelStream struct {
+	ChatCompletionStream
+	model string
+	opts  RequestOptions
+}
+
+func (s *modelStream) Recv() (ChatChunk, error) {
+	chunk, err := s.ChatCompletionStream.Recv()
+	chunk.Model = servedModel(s.model, chunk.Model, s.opts)
+	return chunk, err
+}
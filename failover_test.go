@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// failingProvider fails every chat request with err and records the models asked for.
+type failingProvider struct {
+	Provider
+	err    error
+	models []string
+}
+
+func (p *failingProvider) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error) {
+	p.models = append(p.models, modelName)
+	return ChatResponse{}, p.err
+}
+
+func (p *failingProvider) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	return alias, nil
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantRetry    bool
+		wantFallback bool
+	}{
+		{name: "connection refused", err: &url.Error{Op: "Post", URL: "http://upstream", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, wantRetry: true, wantFallback: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), wantRetry: true, wantFallback: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, wantRetry: true, wantFallback: true},
+		{name: "rate limit", err: &openai.APIError{HTTPStatusCode: 429}, wantRetry: true, wantFallback: true},
+		{name: "server error", err: &openai.APIError{HTTPStatusCode: 502}, wantRetry: true, wantFallback: true},
+		{name: "unknown model", err: &openai.APIError{HTTPStatusCode: 404}, wantFallback: true},
+		{name: "bad request", err: &openai.APIError{HTTPStatusCode: 400}},
+		{name: "cancelled", err: context.Canceled},
+		{name: "completions not supported", err: errCompletionsNotSupported},
+		{name: "embeddings not supported", err: fmt.Errorf("model x: %w", errEmbeddingsNotSupported)},
+		{name: "decode error", err: errors.New("failed to decode Anthropic stream event: unexpected end of JSON input")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.wantRetry {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.wantRetry)
+			}
+			if got := shouldFallback(tt.err); got != tt.wantFallback {
+				t.Errorf("shouldFallback() = %v, want %v", got, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestFailoverNotSupported(t *testing.T) {
+	inner := &failingProvider{err: errCompletionsNotSupported}
+	// A retry would wait for an hour, far beyond the deadline
+	failover := NewFailoverProvider(inner, map[string][]string{"claude": {"llama3"}}, 2, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := failover.Chat(ctx, nil, "claude", RequestOptions{}); !errors.Is(err, errCompletionsNotSupported) {
+		t.Errorf("Chat() error = %v, want %v", err, errCompletionsNotSupported)
+	}
+	if len(inner.models) != 1 || inner.models[0] != "claude" {
+		t.Errorf("Chat() tried %v, want only claude", inner.models)
+	}
+
+	if _, err := failover.Complete(ctx, "func f() {", "}", "claude", RequestOptions{}); !errors.Is(err, errCompletionsNotSupported) {
+		t.Errorf("Complete() error = %v, want %v", err, errCompletionsNotSupported)
+	}
+}
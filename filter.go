@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often the models filter file is checked for changes
+const filterReloadInterval = 2 * time.Second
+
+// errModelNotAllowed is returned for requests to models the models filter excludes.
+var errModelNotAllowed = errors.New("model is not allowed by the models filter")
+
+// modelFilter is the filter currently in use; nil allows all models.
+var modelFilter atomic.Pointer[ModelFilter]
+
+// modelFilterPath is the file the models filter is loaded from. filterFileMu
+// serializes the changes the proxy makes to it.
+var (
+	modelFilterPath string
+	filterFileMu    sync.Mutex
+)
+
+// filterRule is a line of the models filter: a name pattern or a catalog attribute.
+type filterRule struct {
+	deny bool
+
+	// Name patterns
+	exact string
+	glob  string
+	regex *regexp.Regexp
+
+	// Catalog attributes
+	free       bool
+	maxPrice   float64 // USD per million tokens, prompt and completion
+	minContext int
+}
+
+// ModelFilter decides which models are listed and can be used. A model passes if it
+// matches one of the allow patterns (or there are none), has all allowed attributes,
+// and matches no deny rule.
+type ModelFilter struct {
+	rules []filterRule
+	// strict rejects models without catalog data if there are attribute rules,
+	// as for API keys, instead of skipping the attributes
+	strict bool
+}
+
+// filterTarget is a model checked against the filter.
+type filterTarget struct {
+	name string           // Name the model is listed or requested under
+	id   string           // Full model ID
+	meta *openrouterModel // Catalog entry, nil for models of other backends
+}
+
+// loadModelFilter reads a models filter file. Each line is a rule:
+//
+//	deepseek-chat-v3-0324:free   exact name, with or without the vendor
+//	openai/*                     glob pattern
+//	re:^anthropic/claude-3       regular expression
+//	!*-preview                   "!" turns any rule into a deny rule
+//	@free                        only free models
+//	@max_price 1.5               prompt and completion at most 1.5 USD per million tokens
+//	@min_context 32000           context window of at least 32000 tokens
+//
+// "#" starts a comment.
+func loadModelFilter(path string) (*ModelFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	filter := &ModelFilter{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i == 0 || (i > 0 && (line[i-1] == ' ' || line[i-1] == '\t')) {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		rule, err := parseFilterRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, number, err)
+		}
+		filter.rules = append(filter.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func parseFilterRule(line string) (filterRule, error) {
+	var rule filterRule
+	if strings.HasPrefix(line, "!") {
+		rule.deny = true
+		line = strings.TrimSpace(line[1:])
+	}
+
+	switch {
+	case strings.HasPrefix(line, "@"):
+		name, value, _ := strings.Cut(line[1:], " ")
+		value = strings.TrimSpace(value)
+		switch name {
+		case "free":
+			rule.free = true
+		case "max_price":
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil || price < 0 {
+				return rule, fmt.Errorf("invalid @max_price %q", value)
+			}
+			rule.maxPrice = price
+		case "min_context":
+			minContext, err := strconv.Atoi(value)
+			if err != nil || minContext <= 0 {
+				return rule, fmt.Errorf("invalid @min_context %q", value)
+			}
+			rule.minContext = minContext
+		default:
+			return rule, fmt.Errorf("unknown attribute @%s", name)
+		}
+	case strings.HasPrefix(line, "re:"):
+		regex, err := regexp.Compile(line[3:])
+		if err != nil {
+			return rule, fmt.Errorf("invalid regex %q: %w", line[3:], err)
+		}
+		rule.regex = regex
+	case strings.ContainsAny(line, "*?["):
+		if _, err := path.Match(line, ""); err != nil {
+			return rule, fmt.Errorf("invalid glob %q: %w", line, err)
+		}
+		rule.glob = line
+	case line == "":
+		return rule, errors.New("empty rule")
+	default:
+		rule.exact = line
+	}
+	return rule, nil
+}
+
+func (r *filterRule) isAttribute() bool {
+	return r.exact == "" && r.glob == "" && r.regex == nil
+}
+
+// matches reports whether the rule applies to the model. Name patterns are checked
+// against the listed name, the full ID and the ID without the vendor.
+func (r *filterRule) matches(target filterTarget) bool {
+	if r.isAttribute() {
+		return r.hasAttribute(target.meta)
+	}
+
+	names := []string{target.name, target.id, target.id[strings.LastIndex(target.id, "/")+1:]}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		switch {
+		case r.regex != nil:
+			if r.regex.MatchString(name) {
+				return true
+			}
+		case r.glob != "":
+			if ok, _ := path.Match(r.glob, name); ok {
+				return true
+			}
+		default:
+			if name == r.exact {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *filterRule) hasAttribute(model *openrouterModel) bool {
+	prompt, promptKnown := model.price("prompt")
+	completion, completionKnown := model.price("completion")
+	switch {
+	case r.free:
+		return strings.HasSuffix(model.ID, ":free") || (promptKnown && completionKnown && prompt == 0 && completion == 0)
+	case r.minContext > 0:
+		return model.contextLength() >= r.minContext
+	default:
+		// Prices are per token in the catalog
+		return promptKnown && completionKnown && prompt*1e6 <= r.maxPrice && completion*1e6 <= r.maxPrice
+	}
+}
+
+// Allows reports whether the model passes the filter. Attributes are only checked
+// for models of OpenRouter's catalog; a strict filter rejects other models instead.
+func (f *ModelFilter) Allows(target filterTarget) bool {
+	if f == nil {
+		return true
+	}
+	hasAllowPatterns, allowed := false, false
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if rule.isAttribute() && target.meta == nil {
+			if f.strict {
+				return false
+			}
+			continue
+		}
+		matches := rule.matches(target)
+		switch {
+		case rule.deny:
+			if matches {
+				return false
+			}
+		case rule.isAttribute():
+			if !matches {
+				return false
+			}
+		default:
+			hasAllowPatterns = true
+			allowed = allowed || matches
+		}
+	}
+	return allowed || !hasAllowPatterns
+}
+
+// modelAllowed reports whether a listed model passes the models filter.
+func modelAllowed(m Model) bool {
+	id := m.id
+	if id == "" {
+		id = m.Model
+	}
+	return modelFilter.Load().Allows(filterTarget{name: m.Model, id: id, meta: m.meta})
+}
+
+// resolveChatModel resolves the model of a chat or completion request and checks it
+// against the models filter. Aliases are configured explicitly and always allowed.
+func resolveChatModel(ctx context.Context, provider Provider, name string) (string, *modelAlias, error) {
+	fullName, alias, err := resolveModelAlias(ctx, provider, name)
+	if err != nil {
+		return "", nil, err
+	}
+	id, _ := splitRoutingVariant(fullName)
+	target := filterTarget{name: name, id: id, meta: catalogModel(ctx, provider, id)}
+	// The client's API key restricts aliases as well
+	if err := requestKey(ctx).checkModel(target); err != nil {
+		return "", nil, err
+	}
+	if alias != nil {
+		return fullName, alias, nil
+	}
+	if !modelFilter.Load().Allows(target) {
+		return "", nil, fmt.Errorf("%w: %s", errModelNotAllowed, name)
+	}
+	return fullName, nil, nil
+}
+
+// allowModel adds an exact rule for the model to the models filter file, so it is
+// listed from now on. Models that a deny rule or an attribute excludes cannot be
+// allowed this way.
+func allowModel(target filterTarget) error {
+	filterFileMu.Lock()
+	defer filterFileMu.Unlock()
+
+	filter := modelFilter.Load()
+	if filter.Allows(target) {
+		return nil
+	}
+	extended := &ModelFilter{rules: append(append([]filterRule(nil), filter.rules...), filterRule{exact: target.id})}
+	if !extended.Allows(target) {
+		return fmt.Errorf("%w: %s is excluded by a deny rule or attribute", errModelNotAllowed, target.name)
+	}
+
+	file, err := os.OpenFile(modelFilterPath, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	line := target.id + "\n"
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+	if _, err := file.WriteString(line); err != nil {
+		return err
+	}
+	modelFilter.Store(extended)
+	slog.Info("Added model to models filter", "file", modelFilterPath, "model", target.id)
+	return nil
+}
+
+// watchModelFilter loads the models filter and reloads it whenever the file changes.
+// Without the file, all models are allowed. A file that fails to parse on reload
+// leaves the previous filter in place.
+func watchModelFilter(path string) error {
+	modelFilterPath = path
+	load := func() error {
+		filter, err := loadModelFilter(path)
+		if os.IsNotExist(err) {
+			modelFilter.Store(nil)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		modelFilter.Store(filter)
+		slog.Info("Loaded models filter", "file", path, "rules", len(filter.rules))
+		return nil
+	}
+
+	modTime, size := statFile(path)
+	if err := load(); err != nil {
+		return err
+	}
+	if size < 0 {
+		slog.Info("models-filter file not found. Skipping model filtering.", "file", path)
+	}
+
+	go pollFile(path, modTime, size, func(size int64) {
+		if err := load(); err != nil {
+			slog.Error("Error reloading models filter, keeping the previous one", "file", path, "Error", err)
+			return
+		}
+		if size < 0 {
+			slog.Info("models-filter file removed, all models are allowed", "file", path)
+		}
+	})
+	return nil
+}
+
+// statFile returns the modification time and size of a file, size -1 if it is missing.
+func statFile(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
+
+// pollFile calls changed whenever the modification time or size of the file differ
+// from the last ones seen, starting with the given ones.
+func pollFile(path string, modTime time.Time, size int64, changed func(size int64)) {
+	ticker := time.NewTicker(filterReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		newModTime, newSize := statFile(path)
+		if newModTime.Equal(modTime) && newSize == size {
+			continue
+		}
+		modTime, size = newModTime, newSize
+		changed(size)
+	}
+}
+
+// catalogModelProvider is implemented by providers that have catalog metadata of their models.
+type catalogModelProvider interface {
+	CatalogModel(ctx context.Context, id string) *openrouterModel
+}
+
+// catalogModel returns the catalog entry of a resolved model, or nil if there is none.
+func catalogModel(ctx context.Context, provider Provider, id string) *openrouterModel {
+	catalogProvider, ok := provider.(catalogModelProvider)
+	if !ok {
+		return nil
+	}
+	return catalogProvider.CatalogModel(ctx, id)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFilterRule(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    filterRule
+		wantErr bool
+	}{
+		{line: "deepseek-chat", want: filterRule{exact: "deepseek-chat"}},
+		{line: "openai/*", want: filterRule{glob: "openai/*"}},
+		{line: "! *-preview", want: filterRule{deny: true, glob: "*-preview"}},
+		{line: "@free", want: filterRule{free: true}},
+		{line: "@max_price 1.5", want: filterRule{maxPrice: 1.5}},
+		{line: "!@min_context 32000", want: filterRule{deny: true, minContext: 32000}},
+		{line: "re:[", wantErr: true},
+		{line: "openai/[", wantErr: true},
+		{line: "@max_price cheap", wantErr: true},
+		{line: "@max_price -1", wantErr: true},
+		{line: "@min_context 0", wantErr: true},
+		{line: "@open_weights", wantErr: true},
+		{line: "!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseFilterRule(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFilterRule() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFilterRule() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseFilterRule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	rule, err := parseFilterRule("re:^anthropic/claude-3")
+	if err != nil || rule.regex == nil || rule.regex.String() != "^anthropic/claude-3" {
+		t.Errorf("parseFilterRule(re:) = %+v, %v", rule, err)
+	}
+}
+
+func TestModelFilterAllows(t *testing.T) {
+	free := &openrouterModel{ID: "deepseek/deepseek-chat:free", ContextLength: 64000}
+	cheap := &openrouterModel{ID: "openai/gpt-4o-mini", ContextLength: 128000, Pricing: map[string]interface{}{"prompt": "0.00000015", "completion": "0.0000006"}}
+	pricey := &openrouterModel{ID: "openai/gpt-4o-preview", ContextLength: 8000, Pricing: map[string]interface{}{"prompt": "0.000005", "completion": "0.000015"}}
+	variable := &openrouterModel{ID: "openrouter/auto", ContextLength: 2000000, Pricing: map[string]interface{}{"prompt": "-1", "completion": "-1"}}
+	target := func(name string, meta *openrouterModel) filterTarget {
+		if meta == nil {
+			return filterTarget{name: name, id: name}
+		}
+		return filterTarget{name: name, id: meta.ID, meta: meta}
+	}
+
+	tests := []struct {
+		name   string
+		rules  []string
+		strict bool
+		target filterTarget
+		want   bool
+	}{
+		{name: "no rules", target: target("gpt-4o-mini", cheap), want: true},
+		{name: "exact full ID", rules: []string{"openai/gpt-4o-mini"}, target: target("gpt-4o-mini", cheap), want: true},
+		{name: "exact without vendor", rules: []string{"gpt-4o-mini"}, target: target("openai/gpt-4o-mini", cheap), want: true},
+		{name: "no allow pattern matches", rules: []string{"anthropic/*"}, target: target("gpt-4o-mini", cheap), want: false},
+		{name: "one of the allow patterns", rules: []string{"anthropic/*", "openai/*"}, target: target("gpt-4o-mini", cheap), want: true},
+		{name: "regex", rules: []string{"re:^openai/gpt-4o"}, target: target("gpt-4o-mini", cheap), want: true},
+		{name: "deny beats allow", rules: []string{"openai/*", "!*-preview"}, target: target("gpt-4o-preview", pricey), want: false},
+		{name: "deny only", rules: []string{"!*-preview"}, target: target("gpt-4o-mini", cheap), want: true},
+		{name: "free by suffix", rules: []string{"@free"}, target: target("deepseek-chat:free", free), want: true},
+		{name: "not free", rules: []string{"@free"}, target: target("gpt-4o-mini", cheap), want: false},
+		{name: "max price", rules: []string{"@max_price 1"}, target: target("gpt-4o-mini", cheap), want: true},
+		{name: "above max price", rules: []string{"@max_price 1"}, target: target("gpt-4o-preview", pricey), want: false},
+		{name: "variable price", rules: []string{"@max_price 1"}, target: target("auto", variable), want: false},
+		{name: "min context", rules: []string{"@min_context 32000"}, target: target("gpt-4o-preview", pricey), want: false},
+		{name: "attributes are ANDed", rules: []string{"@max_price 1", "@min_context 100000"}, target: target("gpt-4o-mini", cheap), want: true},
+		{name: "denied attribute", rules: []string{"!@free"}, target: target("deepseek-chat:free", free), want: false},
+		{name: "pattern and attribute", rules: []string{"openai/*", "@free"}, target: target("gpt-4o-mini", cheap), want: false},
+		{name: "attribute without catalog", rules: []string{"@free"}, target: target("llama3", nil), want: true},
+		{name: "strict attribute without catalog", rules: []string{"@free"}, strict: true, target: target("llama3", nil), want: false},
+		{name: "strict denied attribute without catalog", rules: []string{"!@free"}, strict: true, target: target("llama3", nil), want: false},
+		{name: "strict patterns without catalog", rules: []string{"llama*", "!*-preview"}, strict: true, target: target("llama3", nil), want: true},
+		{name: "strict attribute in the catalog", rules: []string{"@free"}, strict: true, target: target("deepseek-chat:free", free), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := &ModelFilter{strict: tt.strict}
+			for _, line := range tt.rules {
+				rule, err := parseFilterRule(line)
+				if err != nil {
+					t.Fatalf("parseFilterRule(%q) error = %v", line, err)
+				}
+				filter.rules = append(filter.rules, rule)
+			}
+			if got := filter.Allows(tt.target); got != tt.want {
+				t.Errorf("Allows(%s) = %v, want %v", tt.target.name, got, tt.want)
+			}
+		})
+	}
+
+	var none *ModelFilter
+	if !none.Allows(target("llama3", nil)) {
+		t.Error("a nil filter should allow all models")
+	}
+}
+
+func TestLoadModelFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models-filter")
+	content := "# Models for the team\nopenai/*   # all of OpenAI\n\n!*-preview\n@max_price 2\nfoo#bar\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := loadModelFilter(path)
+	if err != nil {
+		t.Fatalf("loadModelFilter() error = %v", err)
+	}
+	want := []string{"openai/*", "*-preview", "", "foo#bar"}
+	if len(filter.rules) != len(want) {
+		t.Fatalf("loadModelFilter() has %d rules, want %d", len(filter.rules), len(want))
+	}
+	for i, rule := range filter.rules {
+		if name := rule.glob + rule.exact; name != want[i] {
+			t.Errorf("rule %d = %q, want %q", i, name, want[i])
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("openai/*\n@cheap\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadModelFilter(path); err == nil {
+		t.Error("loadModelFilter() with an unknown attribute should fail")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// parseFormat converts Ollama's "format" field into an OpenAI response format.
+// Ollama accepts either the string "json" or a JSON schema object.
+func parseFormat(raw json.RawMessage) (*openai.ChatCompletionResponseFormat, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		return nil, nil
+	}
+
+	var format string
+	if err := json.Unmarshal(raw, &format); err == nil {
+		if format != "json" {
+			return nil, fmt.Errorf("unsupported format %q, expected \"json\" or a JSON schema", format)
+		}
+		return &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}, nil
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("format must be \"json\" or a JSON schema object: %w", err)
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   "response",
+			Schema: raw,
+			Strict: true,
+		},
+	}, nil
+}
+
+// validateFormattedOutput checks that the model output satisfies the requested format.
+func validateFormattedOutput(content string, format *openai.ChatCompletionResponseFormat) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("output is not valid JSON: %w", err)
+	}
+
+	if format.Type != openai.ChatCompletionResponseFormatTypeJSONSchema || format.JSONSchema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("output is not a JSON object")
+		}
+		return nil
+	}
+
+	rawSchema, err := format.JSONSchema.Schema.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(rawSchema, &schema); err != nil {
+		return err
+	}
+	return validateSchema(value, schema, "$")
+}
+
+// validateSchema checks a decoded JSON value against the commonly used subset of
+// JSON schema: type, enum, properties, required, additionalProperties and items.
+func validateSchema(value interface{}, schema map[string]interface{}, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected type %s", path, strings.Join(types, " or "))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				key, _ := name.(string)
+				if _, ok := v[key]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propertySchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateSchema(v[key], propertySchema, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateSchema(item, itemSchema, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func schemaTypes(t interface{}) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		types := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func jsonTypeMatches(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	// Unknown types are not enforced
+	return true
+}
+
+func jsonEqual(a, b interface{}) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+// stripCodeFence removes a markdown code fence around the whole output, if present.
+func stripCodeFence(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") || len(trimmed) < 6 {
+		return content
+	}
+	trimmed = strings.TrimSuffix(trimmed, "```")
+	// Drop the opening fence together with its optional language tag
+	if newline := strings.IndexByte(trimmed, '\n'); newline >= 0 {
+		return strings.TrimSpace(trimmed[newline+1:])
+	}
+	return content
+}
+
+// formatRetryMessage asks the model to correct an output that failed validation.
+func formatRetryMessage(format *openai.ChatCompletionResponseFormat, validationErr error) openai.ChatCompletionMessage {
+	instruction := "Your previous response was invalid: " + validationErr.Error() + ". Respond again with only a valid JSON object"
+	if format.JSONSchema != nil {
+		if schema, err := format.JSONSchema.Schema.MarshalJSON(); err == nil {
+			instruction += " that matches this JSON schema: " + string(schema)
+		}
+	}
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: instruction + ". Do not wrap it in markdown or add any other text.",
+	}
+}
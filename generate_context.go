@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// contextMarker starts the context arrays the proxy returns from /api/generate, to
+// tell them from the token lists of a real Ollama server.
+const contextMarker = 0x6f6c6c61 // "olla"
+
+// generateContexts keeps the conversations of /api/generate. Ollama returns the
+// tokens of the conversation as "context"; the proxy returns a reference to the
+// stored prompts and responses instead, which are replayed as messages when a
+// client sends the context back.
+var generateContexts = &contextStore{
+	ttl:        30 * time.Minute,
+	maxEntries: 1000,
+	maxSize:    256 * 1024,
+	contexts:   make(map[uint64]*generateContext),
+}
+
+// generateContext is a stored conversation. Its messages are never modified once
+// stored; each response creates a new conversation, so a client can continue from
+// any earlier context.
+type generateContext struct {
+	system   string
+	messages []openai.ChatCompletionMessage // Earlier prompts and responses
+	size     int                            // Bytes of the message contents
+	lastUsed time.Time
+}
+
+// contextStore holds the conversations by their ID, limited in number and size.
+type contextStore struct {
+	ttl        time.Duration // Since the last use
+	maxEntries int
+	maxSize    int // Bytes per conversation; the oldest turns are dropped beyond it
+
+	mu       sync.Mutex
+	contexts map[uint64]*generateContext
+}
+
+// loadContextSettings reads GENERATE_CONTEXT_TTL, GENERATE_CONTEXT_MAX_ENTRIES
+// (0 disables the store) and GENERATE_CONTEXT_MAX_SIZE.
+func loadContextSettings() error {
+	if value := os.Getenv("GENERATE_CONTEXT_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid GENERATE_CONTEXT_TTL %q", value)
+		}
+		generateContexts.ttl = ttl
+	}
+	if value := os.Getenv("GENERATE_CONTEXT_MAX_ENTRIES"); value != "" {
+		maxEntries, err := strconv.Atoi(value)
+		if err != nil || maxEntries < 0 {
+			return fmt.Errorf("invalid GENERATE_CONTEXT_MAX_ENTRIES %q", value)
+		}
+		generateContexts.maxEntries = maxEntries
+	}
+	if value := os.Getenv("GENERATE_CONTEXT_MAX_SIZE"); value != "" {
+		maxSize, err := strconv.Atoi(value)
+		if err != nil || maxSize <= 0 {
+			return fmt.Errorf("invalid GENERATE_CONTEXT_MAX_SIZE %q", value)
+		}
+		generateContexts.maxSize = maxSize
+	}
+	return nil
+}
+
+// contextID returns the ID a context array refers to.
+func contextID(tokens []int) (uint64, bool) {
+	if len(tokens) != 3 || tokens[0] != contextMarker || tokens[1] < 0 || tokens[2] < 0 {
+		return 0, false
+	}
+	return uint64(tokens[1])<<31 | uint64(tokens[2]), true
+}
+
+// contextTokens returns the context array of an ID.
+func contextTokens(id uint64) []int {
+	return []int{contextMarker, int(id >> 31), int(id & (1<<31 - 1))}
+}
+
+// Get returns the conversation a context array refers to. It fails for contexts of
+// other servers and for expired or evicted conversations.
+func (s *contextStore) Get(tokens []int) (*generateContext, error) {
+	id, ok := contextID(tokens)
+	if !ok {
+		return nil, errors.New("context was not created by this proxy")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.contexts[id]
+	if !ok || time.Since(stored.lastUsed) > s.ttl {
+		delete(s.contexts, id)
+		return nil, errors.New("context has expired")
+	}
+	stored.lastUsed = time.Now()
+	return stored, nil
+}
+
+// Save stores the conversation continued with a prompt and its response, and
+// returns the context array that refers to it. It returns nil if the store is
+// disabled.
+func (s *contextStore) Save(parent *generateContext, system string, prompt string, response string) []int {
+	if s.maxEntries <= 0 {
+		return nil
+	}
+	stored := &generateContext{system: system, lastUsed: time.Now()}
+	if parent != nil {
+		stored.messages = append(stored.messages, parent.messages...)
+		stored.size = parent.size
+	}
+	stored.messages = append(stored.messages,
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt},
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: response},
+	)
+	stored.size += len(prompt) + len(response)
+
+	// Drop the oldest turns beyond the size limit, but keep the latest one
+	for stored.size > s.maxSize && len(stored.messages) > 2 {
+		stored.size -= len(stored.messages[0].Content) + len(stored.messages[1].Content)
+		stored.messages = stored.messages[2:]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	var id uint64
+	for {
+		var b [8]byte
+		rand.Read(b[:])
+		id = binary.BigEndian.Uint64(b[:]) & (1<<62 - 1)
+		if _, taken := s.contexts[id]; !taken {
+			break
+		}
+	}
+	s.contexts[id] = stored
+	return contextTokens(id)
+}
+
+// evict removes the expired conversations, and the least recently used one if the
+// store is full.
+func (s *contextStore) evict() {
+	var oldestID uint64
+	var oldest *generateContext
+	for id, stored := range s.contexts {
+		if time.Since(stored.lastUsed) > s.ttl {
+			delete(s.contexts, id)
+			continue
+		}
+		if oldest == nil || stored.lastUsed.Before(oldest.lastUsed) {
+			oldestID, oldest = id, stored
+		}
+	}
+	if len(s.contexts) >= s.maxEntries && oldest != nil {
+		delete(s.contexts, oldestID)
+	}
+}
+
+// history returns the earlier prompts and responses of the conversation.
+func (g *generateContext) history() []openai.ChatCompletionMessage {
+	if g == nil {
+		return nil
+	}
+	return g.messages
+}
+
+// systemPrompt returns the system prompt for a request continuing the conversation.
+func (g *generateContext) systemPrompt(system string) string {
+	if system != "" || g == nil {
+		return system
+	}
+	return g.system
+}
+
+// generateMessages returns the messages of a generate request, with the earlier turns
+// of the conversation before the prompt.
+func generateMessages(history []openai.ChatCompletionMessage, prompt string, systemPrompt string, images []string) []openai.ChatCompletionMessage {
+	messages := buildGenerateMessages(prompt, systemPrompt, images)
+	last := len(messages) - 1
+	result := make([]openai.ChatCompletionMessage, 0, len(messages)+len(history))
+	result = append(result, messages[:last]...)
+	result = append(result, history...)
+	return append(result, messages[last])
+}
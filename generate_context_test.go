@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func newTestContextStore(ttl time.Duration, maxEntries int, maxSize int) *contextStore {
+	return &contextStore{ttl: ttl, maxEntries: maxEntries, maxSize: maxSize, contexts: make(map[uint64]*generateContext)}
+}
+
+func TestContextTokens(t *testing.T) {
+	for _, id := range []uint64{0, 1, 1<<31 - 1, 1 << 31, 1<<62 - 1} {
+		got, ok := contextID(contextTokens(id))
+		if !ok || got != id {
+			t.Errorf("contextID(contextTokens(%d)) = %d, %v", id, got, ok)
+		}
+	}
+	for _, tokens := range [][]int{nil, {1, 2, 3}, {contextMarker, 1}, {contextMarker, -1, 2}} {
+		if _, ok := contextID(tokens); ok {
+			t.Errorf("contextID(%v) should not be a context of the proxy", tokens)
+		}
+	}
+}
+
+func TestContextStoreContinues(t *testing.T) {
+	store := newTestContextStore(time.Hour, 10, 1024)
+	first := store.Save(nil, "Be brief.", "Hi", "Hello")
+	parent, err := store.Get(first)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	second := store.Save(parent, parent.systemPrompt(""), "Why?", "Because")
+	conversation, err := store.Get(second)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	want := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "Hi"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "Hello"},
+		{Role: openai.ChatMessageRoleUser, Content: "Why?"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "Because"},
+	}
+	if !reflect.DeepEqual(conversation.history(), want) {
+		t.Errorf("history() = %+v, want %+v", conversation.history(), want)
+	}
+	if got := conversation.systemPrompt(""); got != "Be brief." {
+		t.Errorf("systemPrompt() = %q, want the stored one", got)
+	}
+	if got := conversation.systemPrompt("Be verbose."); got != "Be verbose." {
+		t.Errorf("systemPrompt() = %q, want the request's", got)
+	}
+	// The earlier context is unchanged
+	if parent, _ := store.Get(first); len(parent.history()) != 2 {
+		t.Errorf("the first context has %d messages, want 2", len(parent.history()))
+	}
+
+	if _, err := store.Get([]int{contextMarker, 0, 12345}); err == nil {
+		t.Error("Get() of an unknown context should fail")
+	}
+}
+
+func TestContextStoreExpiry(t *testing.T) {
+	store := newTestContextStore(time.Minute, 10, 1024)
+	tokens := store.Save(nil, "", "Hi", "Hello")
+	id, _ := contextID(tokens)
+	store.contexts[id].lastUsed = time.Now().Add(-2 * time.Minute)
+
+	if _, err := store.Get(tokens); err == nil {
+		t.Fatal("Get() of an expired context should fail")
+	}
+	if _, ok := store.contexts[id]; ok {
+		t.Error("an expired context should be removed")
+	}
+}
+
+func TestContextStoreEviction(t *testing.T) {
+	store := newTestContextStore(time.Hour, 2, 1024)
+	oldest := store.Save(nil, "", "1", "1")
+	newer := store.Save(nil, "", "2", "2")
+	id, _ := contextID(oldest)
+	store.contexts[id].lastUsed = time.Now().Add(-time.Minute)
+
+	newest := store.Save(nil, "", "3", "3")
+	if len(store.contexts) != 2 {
+		t.Fatalf("store has %d contexts, want 2", len(store.contexts))
+	}
+	if _, err := store.Get(oldest); err == nil {
+		t.Error("the least recently used context should be evicted")
+	}
+	for _, tokens := range [][]int{newer, newest} {
+		if _, err := store.Get(tokens); err != nil {
+			t.Errorf("Get() error = %v", err)
+		}
+	}
+
+	if tokens := newTestContextStore(time.Hour, 0, 1024).Save(nil, "", "Hi", "Hello"); tokens != nil {
+		t.Errorf("Save() of a disabled store = %v, want nil", tokens)
+	}
+}
+
+func TestContextStoreSizeLimit(t *testing.T) {
+	store := newTestContextStore(time.Hour, 10, 20)
+	var parent *generateContext
+	for _, turn := range []string{"aaaa", "bbbb", "cccc"} {
+		tokens := store.Save(parent, "", turn, turn)
+		parent, _ = store.Get(tokens)
+	}
+	// 8 bytes per turn, so the first turn is dropped
+	if got := contentsOf(parent.history()); !reflect.DeepEqual(got, []string{"bbbb", "bbbb", "cccc", "cccc"}) {
+		t.Errorf("history() = %v", got)
+	}
+	if parent.size != 16 {
+		t.Errorf("size = %d, want 16", parent.size)
+	}
+
+	// The latest turn is kept even if it is larger than the limit on its own
+	tokens := store.Save(parent, "", "a long prompt", "and a long response")
+	conversation, _ := store.Get(tokens)
+	if got := contentsOf(conversation.history()); !reflect.DeepEqual(got, []string{"a long prompt", "and a long response"}) {
+		t.Errorf("history() = %v", got)
+	}
+}
+
+func contentsOf(messages []openai.ChatCompletionMessage) []string {
+	contents := make([]string, 0, len(messages))
+	for _, message := range messages {
+		contents = append(contents, message.Content)
+	}
+	return contents
+}
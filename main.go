@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -17,63 +16,156 @@ import (
 	openai "github.com/sashabaranov/go-openai"
 )
 
-var modelFilter map[string]struct{}
-
-func loadModelFilter(path string) (map[string]struct{}, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func main() {
+	r := gin.Default()
+	// Load the API key from environment variables or command-line arguments.
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" && len(os.Args) > 1 {
+		apiKey = os.Args[1]
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	filter := make(map[string]struct{})
+	if err := loadProviderPreferences(); err != nil {
+		slog.Error("Error configuring OpenRouter provider preferences", "Error", err)
+		return
+	}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			filter[line] = struct{}{}
+	// Route requests to several backends if a routes file exists, otherwise
+	// use the single backend selected with the PROVIDER environment variable
+	routesFile := os.Getenv("ROUTES_FILE")
+	if routesFile == "" {
+		routesFile = "routes.json"
+	}
+	var provider Provider
+	routingConfig, err := loadRoutingConfig(routesFile)
+	if err == nil {
+		router, err := NewRouter(routingConfig, apiKey)
+		if err != nil {
+			slog.Error("Error creating router", "file", routesFile, "Error", err)
+			return
+		}
+		slog.Info("Loaded routing table", "file", routesFile, "backends", len(routingConfig.Backends), "routes", len(routingConfig.Routes))
+		provider = router
+	} else if os.IsNotExist(err) {
+		provider, err = NewProvider(backendConfigFromEnv(apiKey))
+		if err != nil {
+			slog.Error("Error creating provider", "Error", err)
+			return
 		}
+	} else {
+		slog.Error("Error loading routes file", "file", routesFile, "Error", err)
+		return
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	timeout, err := loadUpstreamTimeout()
+	if err != nil {
+		slog.Error("Invalid UPSTREAM_TIMEOUT", "Error", err)
+		return
+	}
+	upstreamTimeout = timeout
+	if upstreamTimeout > 0 {
+		slog.Info("Using upstream timeout", "timeout", upstreamTimeout)
 	}
 
-	return filter, nil
-}
-
-func main() {
-	r := gin.Default()
-	// Load the API key from environment variables or command-line arguments.
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		if len(os.Args) > 1 {
-			apiKey = os.Args[1]
-		} else {
-			slog.Error("OPENAI_API_KEY environment variable or command-line argument not set.")
+	// Retry failed upstream requests and fall back to other models
+	retries, backoff, err := loadRetrySettings()
+	if err != nil {
+		slog.Error("Invalid retry settings", "Error", err)
+		return
+	}
+	fallbacksFile := os.Getenv("FALLBACKS_FILE")
+	if fallbacksFile == "" {
+		fallbacksFile = "fallbacks"
+	}
+	fallbacks, err := loadFallbacks(fallbacksFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Error loading fallbacks", "file", fallbacksFile, "Error", err)
 			return
 		}
+		fallbacks = make(map[string][]string)
+	} else {
+		slog.Info("Loaded fallback chains", "file", fallbacksFile, "chains", len(fallbacks))
 	}
+	provider = NewFailoverProvider(provider, fallbacks, retries, backoff)
 
-	provider := NewOpenrouterProvider(apiKey)
+	embeddings, err := loadEmbeddingService(provider, apiKey, retries, backoff)
+	if err != nil {
+		slog.Error("Error configuring embeddings", "Error", err)
+		return
+	}
 
-	filter, err := loadModelFilter("models-filter")
+	if err := loadPullSettings(); err != nil {
+		slog.Error("Error configuring pull", "Error", err)
+		return
+	}
+	if err := loadContextSettings(); err != nil {
+		slog.Error("Error configuring generate contexts", "Error", err)
+		return
+	}
+	if err := loadThinkingSettings(); err != nil {
+		slog.Error("Error configuring thinking", "Error", err)
+		return
+	}
+	if err := loadKeepAlive(); err != nil {
+		slog.Error("Error configuring keep alive", "Error", err)
+		return
+	}
+	if err := loadModelNameStyle(); err != nil {
+		slog.Error("Error configuring model names", "Error", err)
+		return
+	}
+	aliasesFile := os.Getenv("ALIASES_FILE")
+	if aliasesFile == "" {
+		aliasesFile = "aliases.json"
+	}
+	aliases, err := loadModelAliases(aliasesFile)
 	if err != nil {
-		if os.IsNotExist(err) {
-			slog.Info("models-filter file not found. Skipping model filtering.")
-			modelFilter = make(map[string]struct{})
-		} else {
-			slog.Error("Error loading models filter", "Error", err)
+		if !os.IsNotExist(err) {
+			slog.Error("Error loading model aliases", "file", aliasesFile, "Error", err)
 			return
 		}
 	} else {
-		modelFilter = filter
-		slog.Info("Loaded models from filter:")
-		for model := range modelFilter {
-			slog.Info(" - " + model)
+		modelAliases = aliases
+		slog.Info("Loaded model aliases", "file", aliasesFile, "aliases", len(aliases))
+	}
+
+	storeFile := os.Getenv("CREATED_MODELS_FILE")
+	if storeFile == "" {
+		storeFile = "created-models.json"
+	}
+	virtualModels, err = loadModelStore(storeFile)
+	if err != nil {
+		slog.Error("Error loading created models", "file", storeFile, "Error", err)
+		return
+	}
+
+	usageFile := os.Getenv("USAGE_FILE")
+	if usageFile == "" {
+		usageFile = "usage.jsonl"
+	}
+	usageLog, err = loadUsageStore(usageFile)
+	if err != nil {
+		slog.Error("Error loading usage records", "file", usageFile, "Error", err)
+		return
+	}
+
+	filterFile := os.Getenv("MODELS_FILTER_FILE")
+	if filterFile == "" {
+		filterFile = "models-filter"
+	}
+	if err := watchModelFilter(filterFile); err != nil {
+		slog.Error("Error loading models filter", "Error", err)
+		return
+	}
+
+	// Clients authenticate once API keys are configured
+	if keysFile := os.Getenv("API_KEYS_FILE"); keysFile != "" {
+		if err := watchAPIKeys(keysFile); err != nil {
+			slog.Error("Error loading API keys", "file", keysFile, "Error", err)
+			return
 		}
 	}
+	r.Use(requireAPIKey)
 
 	r.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "Ollama is running")
@@ -83,21 +175,20 @@ func main() {
 	})
 
 	r.GET("/api/tags", func(c *gin.Context) {
-		models, err := provider.GetModels()
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		models, err := provider.GetModels(ctx)
 		if err != nil {
-			slog.Error("Error getting models", "Error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			handleUpstreamError(c, "Error getting models", err)
 			return
 		}
-		filter := modelFilter
 		// Construct a new array of model objects with extra fields
 		newModels := make([]map[string]interface{}, 0, len(models))
+		key := requestKey(ctx)
 		for _, m := range models {
-			// Если фильтр пустой, значит пропускаем проверку и берём все модели
-			if len(filter) > 0 {
-				if _, ok := filter[m.Model]; !ok {
-					continue
-				}
+			if !modelAllowed(m) || !key.listed(m) {
+				continue
 			}
 			newModels = append(newModels, map[string]interface{}{
 				"name":        m.Name,
@@ -108,10 +199,41 @@ func main() {
 				"details":     m.Details,
 			})
 		}
+		// Aliases and embedding models are configured explicitly, so the filter does not apply to them
+		for _, m := range append(aliasModels(models), embeddings.Models()...) {
+			if !key.listed(m) {
+				continue
+			}
+			newModels = append(newModels, map[string]interface{}{
+				"name":        m.Name,
+				"model":       m.Model,
+				"modified_at": m.ModifiedAt,
+				"size":        0,
+				"digest":      m.Digest,
+				"details":     m.Details,
+			})
+		}
 
 		c.JSON(http.StatusOK, gin.H{"models": newModels})
 	})
 
+	// Fetch the model catalog now instead of waiting for the next background refresh
+	r.POST("/proxy/models/refresh", requireAdmin, func(c *gin.Context) {
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		if err := refreshModels(ctx, provider); err != nil {
+			handleUpstreamError(c, "Error refreshing models", err)
+			return
+		}
+		models, err := provider.GetModels(ctx)
+		if err != nil {
+			handleUpstreamError(c, "Error getting models", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "models": len(models)})
+	})
+
 	r.POST("/api/show", func(c *gin.Context) {
 		var request map[string]string
 		if err := c.BindJSON(&request); err != nil {
@@ -120,21 +242,41 @@ func main() {
 		}
 
 		modelName := request["name"]
+		if modelName == "" {
+			// Newer Ollama clients send the name as "model"
+			modelName = request["model"]
+		}
 		if modelName == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Model name is required"})
 			return
 		}
 
-		details, err := provider.GetModelDetails(modelName)
+		ctx := c.Request.Context()
+		alias := lookupAlias(ctx, provider, modelName)
+		if alias != nil {
+			modelName = alias.Model
+		}
+		details, err := provider.GetModelDetails(ctx, modelName)
+		var ambiguous *ambiguousModelError
+		if errors.Is(err, errModelNotFound) || errors.As(err, &ambiguous) {
+			c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		if alias != nil {
+			alias.show(details)
+		}
 		c.JSON(http.StatusOK, details)
 	})
 
 	r.POST("/api/chat", func(c *gin.Context) {
+		// Measure the request for the statistics in the final response
+		stats := newGenerationStats()
+
 		// Read the raw request body
 		rawBody, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -148,9 +290,11 @@ func main() {
 		
 		// Define a custom struct specifically for message with images
 		type MessageWithImages struct {
-			Role    string   `json:"role"`
-			Content string   `json:"content"`
-			Images  []string `json:"images,omitempty"`
+			Role      string           `json:"role"`
+			Content   string           `json:"content"`
+			Images    []string         `json:"images,omitempty"`
+			ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+			ToolName  string           `json:"tool_name,omitempty"`
 		}
 		
 		// Define a custom request struct that can properly handle images in messages
@@ -159,8 +303,11 @@ func main() {
 			Messages []MessageWithImages `json:"messages"`
 			Stream   *bool              `json:"stream"`
 			Images   []string           `json:"images,omitempty"`
+			Tools    []openai.Tool      `json:"tools,omitempty"`
+			Format   json.RawMessage    `json:"format,omitempty"`
 			Options  map[string]interface{} `json:"options,omitempty"`
-			KeepAlive int                `json:"keep_alive,omitempty"`
+			KeepAlive *keepAlive         `json:"keep_alive,omitempty"`
+			Think    *thinkOption       `json:"think,omitempty"`
 		}
 		
 		// Parse the raw JSON directly to catch images in messages
@@ -177,22 +324,48 @@ func main() {
 			Messages []openai.ChatCompletionMessage `json:"messages"`
 			Stream   *bool                          `json:"stream"`
 			Images   []string                       `json:"images,omitempty"`
+			Tools    []openai.Tool                  `json:"tools,omitempty"`
 		}
 		
 		// Fill in the standard fields
 		request.Model = customRequest.Model
 		request.Stream = customRequest.Stream
 		request.Images = customRequest.Images
+		request.Tools = customRequest.Tools
 		
 		// Convert custom messages to standard messages
-		for _, customMsg := range customRequest.Messages {
+		toolNames := make([]string, 0, len(customRequest.Messages))
+		for i, customMsg := range customRequest.Messages {
 			stdMsg := openai.ChatCompletionMessage{
 				Role:    customMsg.Role,
 				Content: customMsg.Content,
 			}
+			if len(customMsg.ToolCalls) > 0 {
+				stdMsg.ToolCalls = toOpenAIToolCalls(customMsg.ToolCalls, i)
+			}
 			request.Messages = append(request.Messages, stdMsg)
+			toolNames = append(toolNames, customMsg.ToolName)
 		}
 		
+		// Link tool results to the tool calls they answer
+		resolveToolCallIDs(request.Messages, toolNames)
+
+		// Structured output requested by the client
+		format, err := parseFormat(customRequest.Format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Options forwarded to the upstream model
+		opts := RequestOptions{
+			Tools:     request.Tools,
+			Options:   customRequest.Options,
+			Format:    format,
+			Reasoning: customRequest.Think.reasoning(),
+		}
+		thinking := newThinkingParser(customRequest.Think)
+		
 		// Log the entire request message
 		// requestJson, _ := json.MarshalIndent(request, "", "  ")
 		// slog.Info("Chat request received", 
@@ -315,7 +488,7 @@ func main() {
 					})
 					slog.Info("Added top-level image to multimodal message", 
 						"imageIndex", i, 
-						"imageSize", len(imgBase64)/1024, "KB")
+						"imageSizeKB", len(imgBase64)/1024)
 				}
 				
 				// Replace the user message with the multimodal content
@@ -334,26 +507,52 @@ func main() {
 			streamRequested = *request.Stream
 		}
 
+		// Upstream calls end together with the client request
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		// Like Ollama, a request without messages only loads or unloads the model
+		if len(request.Messages) == 0 {
+			fullModelName, _, err := resolveChatModel(ctx, provider, request.Model)
+			if err != nil {
+				c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			loadedModels.Touch(ctx, provider, request.Model, fullModelName, customRequest.KeepAlive)
+			c.JSON(http.StatusOK, gin.H{
+				"model":       request.Model,
+				"created_at":  time.Now().Format(time.RFC3339),
+				"message":     gin.H{"role": "assistant", "content": ""},
+				"done_reason": customRequest.KeepAlive.doneReason(),
+				"done":        true,
+			})
+			return
+		}
+
 		// Если стриминг не запрошен, нужно будет реализовать отдельную логику
 		// для сбора полного ответа и отправки его одним JSON.
 		// Пока реализуем только стриминг.
 		if !streamRequested {
 			// Handle non-streaming response
-			fullModelName, err := provider.GetFullModelName(request.Model)
+			fullModelName, alias, err := resolveChatModel(ctx, provider, request.Model)
 			if err != nil {
 				slog.Error("Error getting full model name", "Error", err)
 				// Ollama returns 404 for invalid model names
-				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
 				return
 			}
+			alias.applyDefaults(&opts)
+			request.Messages = alias.chatMessages(request.Messages)
 
 			// Call Chat to get the complete response
-			response, err := provider.Chat(request.Messages, fullModelName)
+			response, err := provider.Chat(ctx, request.Messages, fullModelName, opts)
 			if err != nil {
-				slog.Error("Failed to get chat response", "Error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				handleUpstreamError(c, "Failed to get chat response", err)
 				return
 			}
+			loadedModels.Touch(ctx, provider, request.Model, fullModelName, customRequest.KeepAlive)
+			stats.SetUsage(response.Usage)
+			stats.Done()
 
 			// Format the response according to Ollama's format
 			if len(response.Choices) == 0 {
@@ -361,11 +560,12 @@ func main() {
 				return
 			}
 
-			// Extract the content from the response
+			// Extract the content from the response, without the model's reasoning
 			content := ""
 			if len(response.Choices) > 0 && response.Choices[0].Message.Content != "" {
 				content = response.Choices[0].Message.Content
 			}
+			reasoning, content := thinking.Split(response.Reasoning, content)
 
 			// Get finish reason, default to "stop" if not provided
 			finishReason := "stop"
@@ -373,21 +573,33 @@ func main() {
 				finishReason = string(response.Choices[0].FinishReason)
 			}
 
+			message := map[string]interface{}{
+				"role":    "assistant",
+				"content": content,
+			}
+			if reasoning != "" {
+				message["thinking"] = reasoning
+			}
+			if toolCalls := response.Choices[0].Message.ToolCalls; len(toolCalls) > 0 {
+				message["tool_calls"] = toOllamaToolCalls(toolCalls)
+			}
+
+			// Report the model that answered, which differs after a fallback
+			modelName := fullModelName
+			if response.Model != "" {
+				modelName = response.Model
+			}
+
 			// Create Ollama-compatible response
 			ollamaResponse := map[string]interface{}{
-				"model":             fullModelName,
+				"model":             modelName,
 				"created_at":        time.Now().Format(time.RFC3339),
-				"message": map[string]string{
-					"role":    "assistant",
-					"content": content,
-				},
+				"message":           message,
 				"done":              true,
 				"finish_reason":     finishReason,
-				"total_duration":    response.Usage.TotalTokens * 10, // Approximate duration based on token count
-				"load_duration":     0,
-				"prompt_eval_count": response.Usage.PromptTokens,
-				"eval_count":        response.Usage.CompletionTokens,
-				"eval_duration":     response.Usage.CompletionTokens * 10, // Approximate duration based on token count
+			}
+			for key, value := range stats.Fields() {
+				ollamaResponse[key] = value
 			}
 
 			c.JSON(http.StatusOK, ollamaResponse)
@@ -395,23 +607,26 @@ func main() {
 		}
 
 		slog.Info("Requested model", "model", request.Model)
-		fullModelName, err := provider.GetFullModelName(request.Model)
+		fullModelName, alias, err := resolveChatModel(ctx, provider, request.Model)
 		if err != nil {
 			slog.Error("Error getting full model name", "Error", err, "model", request.Model)
 			// Ollama возвращает 404 на неправильное имя модели
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
+		alias.applyDefaults(&opts)
+		request.Messages = alias.chatMessages(request.Messages)
 		slog.Info("Using model", "fullModelName", fullModelName)
 
 		// Call ChatStream to get the stream
-		stream, err := provider.ChatStream(request.Messages, fullModelName)
+		stream, err := provider.ChatStream(ctx, request.Messages, fullModelName, opts)
 		if err != nil {
-			slog.Error("Failed to create stream", "Error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			handleUpstreamError(c, "Failed to create stream", err)
 			return
 		}
+		loadedModels.Touch(ctx, provider, request.Model, fullModelName, customRequest.KeepAlive)
 		defer stream.Close() // Ensure stream closure
+		stats.StreamOpened()
 
 		// --- ИСПРАВЛЕНИЯ для NDJSON (Ollama-style) ---
 
@@ -430,6 +645,8 @@ func main() {
 		}
 
 		var lastFinishReason string
+		toolCalls := newToolCallAccumulator()
+		modelName := fullModelName // Model that answers, which differs after a fallback
 
 		// Stream responses back to the client
 		for {
@@ -439,7 +656,9 @@ func main() {
 				break
 			}
 			if err != nil {
-				slog.Error("Backend stream error", "Error", err)
+				if !logStreamError(err) {
+					return
+				}
 				// Попытка отправить ошибку в формате NDJSON
 				// Ollama обычно просто обрывает соединение или шлет 500 перед этим
 				errorMsg := map[string]string{"error": "Stream error: " + err.Error()}
@@ -448,21 +667,49 @@ func main() {
 				flusher.Flush()
 				return
 			}
+			stats.Chunk(response)
+			if response.Model != "" {
+				modelName = response.Model
+			}
+
+			// Chunks without choices carry no content (e.g. the final usage chunk)
+			if len(response.Choices) == 0 {
+				continue
+			}
 
 			// Сохраняем причину остановки, если она есть в чанке
-			if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
+			if response.Choices[0].FinishReason != "" {
 				lastFinishReason = string(response.Choices[0].FinishReason)
 			}
 
+			// Tool calls arrive in fragments; they are sent as one chunk once complete
+			delta := response.Choices[0].Delta
+			if len(delta.ToolCalls) > 0 {
+				toolCalls.Add(delta.ToolCalls)
+				if delta.Content == "" && response.Reasoning == "" {
+					continue
+				}
+			}
+
+			// The reasoning goes in "thinking"; parts of a tag are held back
+			reasoning, content := thinking.Add(response.Reasoning, delta.Content)
+			if (delta.Content != "" || response.Reasoning != "") && reasoning == "" && content == "" {
+				continue
+			}
+			message := map[string]interface{}{
+				"role":    "assistant",
+				"content": content, // Может быть ""
+			}
+			if reasoning != "" {
+				message["thinking"] = reasoning
+			}
+
 			// Build JSON response structure for intermediate chunks (Ollama chat format)
 			responseJSON := map[string]interface{}{
-				"model":      fullModelName,
+				"model":      modelName,
 				"created_at": time.Now().Format(time.RFC3339),
-				"message": map[string]string{
-					"role":    "assistant",
-					"content": response.Choices[0].Delta.Content, // Может быть ""
-				},
-				"done": false, // Всегда false для промежуточных чанков
+				"message":    message,
+				"done":       false, // Всегда false для промежуточных чанков
 			}
 
 			// Marshal JSON
@@ -479,6 +726,26 @@ func main() {
 			flusher.Flush()
 		}
 
+		// Send the collected tool calls the way Ollama does: one chunk with complete calls
+		if !toolCalls.Empty() {
+			toolCallJSON, err := json.Marshal(map[string]interface{}{
+				"model":      modelName,
+				"created_at": time.Now().Format(time.RFC3339),
+				"message": map[string]interface{}{
+					"role":       "assistant",
+					"content":    "",
+					"tool_calls": toOllamaToolCalls(toolCalls.ToolCalls()),
+				},
+				"done": false,
+			})
+			if err != nil {
+				slog.Error("Error marshaling tool call response JSON", "Error", err)
+				return
+			}
+			fmt.Fprintf(w, "%s\n", string(toolCallJSON))
+			flusher.Flush()
+		}
+
 		// --- Отправка финального сообщения (done: true) в стиле Ollama ---
 
 		// Определяем причину остановки (если бэкенд не дал, ставим 'stop')
@@ -487,21 +754,26 @@ func main() {
 			lastFinishReason = "stop"
 		}
 
-		// ВАЖНО: Замените nil на 0 для числовых полей статистики
+		// Final response carries the real token counts and measured durations, and
+		// what the thinking parser held back at the end of the stream
+		stats.Done()
+		reasoning, rest := thinking.Flush()
+		finalMessage := map[string]string{
+			"role":    "assistant",
+			"content": rest, // Обычно пустой
+		}
+		if reasoning != "" {
+			finalMessage["thinking"] = reasoning
+		}
 		finalResponse := map[string]interface{}{
-			"model":             fullModelName,
+			"model":             modelName,
 			"created_at":        time.Now().Format(time.RFC3339),
-			"message": map[string]string{
-				"role":    "assistant",
-				"content": "", // Пустой контент для финального сообщения
-			},
+			"message":           finalMessage,
 			"done":              true,
 			"finish_reason":     lastFinishReason, // Необязательно для /api/chat Ollama, но не вредит
-			"total_duration":    0,
-			"load_duration":     0,
-			"prompt_eval_count": 0, // <--- ИЗМЕНЕНО: nil заменен на 0
-			"eval_count":        0, // <--- ИЗМЕНЕНО: nil заменен на 0
-			"eval_duration":     0,
+		}
+		for key, value := range stats.Fields() {
+			finalResponse[key] = value
 		}
 
 		finalJsonData, err := json.Marshal(finalResponse)
@@ -524,6 +796,9 @@ func main() {
 	// --- Chat API endpoint (existing code) ---
 
 	r.POST("/api/generate", func(c *gin.Context) {
+		// Measure the request for the statistics in the final response
+		stats := newGenerationStats()
+
 		// Read the raw request body
 		rawBody, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -541,15 +816,17 @@ func main() {
 		var request struct {
 			Model    string   `json:"model"`
 			Prompt   string   `json:"prompt"`
+			Suffix   string   `json:"suffix,omitempty"`
 			System   string   `json:"system,omitempty"`
 			Stream   *bool    `json:"stream"`
 			Raw      bool     `json:"raw,omitempty"`
 			Images   []string `json:"images,omitempty"`
-			Format   string   `json:"format,omitempty"`
+			Format   json.RawMessage `json:"format,omitempty"`
 			Options  map[string]interface{} `json:"options,omitempty"`
 			Template string   `json:"template,omitempty"`
 			Context  []int    `json:"context,omitempty"`
-			KeepAlive string  `json:"keep_alive,omitempty"`
+			KeepAlive *keepAlive `json:"keep_alive,omitempty"`
+			Think    *thinkOption `json:"think,omitempty"`
 		}
 
 		// Parse the JSON request
@@ -581,31 +858,106 @@ func main() {
 		// 	}
 		// }
 
+		// Structured output requested by the client
+		format, err := parseFormat(request.Format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts := RequestOptions{
+			Options:   request.Options,
+			Format:    format,
+			Reasoning: request.Think.reasoning(),
+		}
+		thinking := newThinkingParser(request.Think)
+
 		// Determine if streaming is requested (default to true if not specified)
 		streamRequested := true
 		if request.Stream != nil {
 			streamRequested = *request.Stream
 		}
 
+		// Upstream calls end together with the client request
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
 		// Get the full model name from the provider
 		slog.Info("Requested model", "model", request.Model)
-		fullModelName, err := provider.GetFullModelName(request.Model)
+		fullModelName, alias, err := resolveChatModel(ctx, provider, request.Model)
 		if err != nil {
 			slog.Error("Error getting full model name", "Error", err, "model", request.Model)
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		alias.applyDefaults(&opts)
+
+		// Raw prompts, templates and suffixes (fill-in-the-middle) go to the text
+		// completions endpoint, without the chat template of the upstream. The template
+		// of a created model applies to requests that could use it.
+		template := request.Template
+		if template == "" && !request.Raw && len(request.Images) == 0 {
+			template = alias.promptTemplate()
+		}
+		useCompletions := request.Raw || template != "" || request.Suffix != ""
+		if useCompletions && len(request.Images) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "images are not supported with raw prompts, templates or suffixes"})
 			return
 		}
+
+		// Continue the conversation of an earlier response
+		var conversation *generateContext
+		if len(request.Context) > 0 && useCompletions {
+			slog.Warn("Ignoring generate context of a raw prompt, template or suffix request")
+		} else if len(request.Context) > 0 {
+			conversation, err = generateContexts.Get(request.Context)
+			if err != nil {
+				slog.Warn("Ignoring generate context, continuing without history", "Error", err)
+			}
+		}
+		request.System = alias.systemPrompt(conversation.systemPrompt(request.System))
+
+		prompt, suffix := request.Prompt, request.Suffix
+		if useCompletions {
+			if request.Raw {
+				template = ""
+			}
+			prompt, suffix, err = rawPrompt(fullModelName, template, request.System, prompt, suffix)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
 		slog.Info("Using model", "fullModelName", fullModelName)
 
+		// Like Ollama, a request without a prompt only loads or unloads the model
+		if request.Prompt == "" && request.Suffix == "" && len(request.Images) == 0 {
+			loadedModels.Touch(ctx, provider, request.Model, fullModelName, request.KeepAlive)
+			c.JSON(http.StatusOK, gin.H{
+				"model":       request.Model,
+				"created_at":  time.Now().Format(time.RFC3339),
+				"response":    "",
+				"done_reason": request.KeepAlive.doneReason(),
+				"done":        true,
+			})
+			return
+		}
+
 		// Handle non-streaming request
 		if !streamRequested {
 			// Call Generate to get a complete response
-			response, err := provider.Generate(request.Prompt, fullModelName, request.System, request.Images)
+			var response ChatResponse
+			if useCompletions {
+				response, err = complete(ctx, provider, prompt, suffix, fullModelName, opts)
+			} else {
+				response, err = alias.generate(ctx, provider, conversation.history(), request.Prompt, fullModelName, request.System, request.Images, opts)
+			}
 			if err != nil {
-				slog.Error("Failed to get generate response", "Error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				handleUpstreamError(c, "Failed to get generate response", err)
 				return
 			}
+			loadedModels.Touch(ctx, provider, request.Model, fullModelName, request.KeepAlive)
+			stats.SetUsage(response.Usage)
+			stats.Done()
 
 			// Format the response according to Ollama's format
 			if len(response.Choices) == 0 {
@@ -613,11 +965,12 @@ func main() {
 				return
 			}
 
-			// Extract content from the response
+			// Extract content from the response, without the model's reasoning
 			content := ""
 			if len(response.Choices) > 0 && response.Choices[0].Message.Content != "" {
 				content = response.Choices[0].Message.Content
 			}
+			reasoning, content := thinking.Split(response.Reasoning, content)
 
 			// Get finish reason, default to "stop" if not provided
 			finishReason := "stop"
@@ -625,20 +978,31 @@ func main() {
 				finishReason = string(response.Choices[0].FinishReason)
 			}
 
+			// Report the model that answered, which differs after a fallback
+			modelName := fullModelName
+			if response.Model != "" {
+				modelName = response.Model
+			}
+
 			// Create Ollama-compatible response
 			ollamaResponse := map[string]interface{}{
-				"model":               fullModelName,
+				"model":               modelName,
 				"created_at":          time.Now().Format(time.RFC3339),
 				"response":            content,
 				"done":                true,
 				"done_reason":         finishReason,
-				"context":             []int{1, 2, 3}, // Placeholder context
-				"total_duration":      response.Usage.TotalTokens * 10000000, // Approximate duration in ns
-				"load_duration":       5000000, // Placeholder 5ms in ns
-				"prompt_eval_count":   response.Usage.PromptTokens,
-				"prompt_eval_duration": response.Usage.PromptTokens * 10000000, // Approximate
-				"eval_count":          response.Usage.CompletionTokens,
-				"eval_duration":       response.Usage.CompletionTokens * 10000000, // Approximate
+			}
+			if reasoning != "" {
+				ollamaResponse["thinking"] = reasoning
+			}
+			// Like Ollama, raw prompts get no context
+			if !useCompletions {
+				if tokens := generateContexts.Save(conversation, request.System, request.Prompt, content); tokens != nil {
+					ollamaResponse["context"] = tokens
+				}
+			}
+			for key, value := range stats.Fields() {
+				ollamaResponse[key] = value
 			}
 
 			c.JSON(http.StatusOK, ollamaResponse)
@@ -646,13 +1010,19 @@ func main() {
 		}
 
 		// Handle streaming request
-		stream, err := provider.GenerateStream(request.Prompt, fullModelName, request.System, request.Images)
+		var stream ChatCompletionStream
+		if useCompletions {
+			stream, err = completeStream(ctx, provider, prompt, suffix, fullModelName, opts)
+		} else {
+			stream, err = alias.generateStream(ctx, provider, conversation.history(), request.Prompt, fullModelName, request.System, request.Images, opts)
+		}
 		if err != nil {
-			slog.Error("Failed to create generate stream", "Error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			handleUpstreamError(c, "Failed to create generate stream", err)
 			return
 		}
+		loadedModels.Touch(ctx, provider, request.Model, fullModelName, request.KeepAlive)
 		defer stream.Close()
+		stats.StreamOpened()
 
 		// Set headers for NDJSON streaming response
 		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
@@ -667,6 +1037,8 @@ func main() {
 		}
 
 		var lastFinishReason string
+		var content strings.Builder // Complete response, for the context
+		modelName := fullModelName // Model that answers, which differs after a fallback
 
 		// Stream responses back to the client in Ollama's format
 		for {
@@ -676,26 +1048,48 @@ func main() {
 				break
 			}
 			if err != nil {
-				slog.Error("Backend stream error", "Error", err)
+				if !logStreamError(err) {
+					return
+				}
 				errorMsg := map[string]string{"error": "Stream error: " + err.Error()}
 				errorJson, _ := json.Marshal(errorMsg)
 				fmt.Fprintf(w, "%s\n", string(errorJson))
 				flusher.Flush()
 				return
 			}
+			stats.Chunk(response)
+			if response.Model != "" {
+				modelName = response.Model
+			}
+
+			// Chunks without choices carry no content (e.g. the final usage chunk)
+			if len(response.Choices) == 0 {
+				continue
+			}
 
 			// Save finish reason if available
-			if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
+			if response.Choices[0].FinishReason != "" {
 				lastFinishReason = string(response.Choices[0].FinishReason)
 			}
 
+			// The reasoning goes in "thinking"; parts of a tag are held back
+			delta := response.Choices[0].Delta
+			reasoning, text := thinking.Add(response.Reasoning, delta.Content)
+			if (delta.Content != "" || response.Reasoning != "") && reasoning == "" && text == "" {
+				continue
+			}
+			content.WriteString(text)
+
 			// Build JSON response structure for intermediate chunks (Ollama generate format)
 			responseJSON := map[string]interface{}{
-				"model":      fullModelName,
+				"model":      modelName,
 				"created_at": time.Now().Format(time.RFC3339),
-				"response":   response.Choices[0].Delta.Content,
+				"response":   text,
 				"done":       false,
 			}
+			if reasoning != "" {
+				responseJSON["thinking"] = reasoning
+			}
 
 			// Marshal and send
 			jsonData, err := json.Marshal(responseJSON)
@@ -713,19 +1107,27 @@ func main() {
 			lastFinishReason = "stop"
 		}
 
+		// Along with what the thinking parser held back at the end of the stream
+		stats.Done()
+		reasoning, rest := thinking.Flush()
+		content.WriteString(rest)
 		finalResponse := map[string]interface{}{
-			"model":               fullModelName,
+			"model":               modelName,
 			"created_at":          time.Now().Format(time.RFC3339),
-			"response":            "",
+			"response":            rest,
 			"done":                true,
 			"done_reason":         lastFinishReason,
-			"context":             []int{1, 2, 3}, // Placeholder context
-			"total_duration":      1000000000, // Placeholder 1s in ns
-			"load_duration":       5000000, // Placeholder 5ms in ns
-			"prompt_eval_count":   20, // Placeholder
-			"prompt_eval_duration": 200000000, // Placeholder 200ms in ns
-			"eval_count":          100, // Placeholder
-			"eval_duration":       800000000, // Placeholder 800ms in ns
+		}
+		if reasoning != "" {
+			finalResponse["thinking"] = reasoning
+		}
+		if !useCompletions {
+			if tokens := generateContexts.Save(conversation, request.System, request.Prompt, content.String()); tokens != nil {
+				finalResponse["context"] = tokens
+			}
+		}
+		for key, value := range stats.Fields() {
+			finalResponse[key] = value
 		}
 
 		finalJsonData, err := json.Marshal(finalResponse)
@@ -738,5 +1140,15 @@ func main() {
 		flusher.Flush()
 	})
 
+	registerEmbeddingRoutes(r, embeddings)
+	registerModelStoreRoutes(r, provider)
+	registerPullRoutes(r, provider, embeddings)
+	registerRunningRoutes(r)
+	registerVersionRoutes(r)
+	registerUsageRoutes(r)
+
+	// OpenAI-compatible API, served from the same providers
+	registerOpenAIRoutes(r, provider, embeddings)
+
 	r.Run(":11434")
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parameters a Modelfile may set: the options applyOllamaOptions maps, and the
+// ones that are accepted but ignored upstream.
+var modelfileParameters = map[string]struct{}{
+	"temperature":       {},
+	"top_p":             {},
+	"top_k":             {},
+	"min_p":             {},
+	"num_predict":       {},
+	"stop":              {},
+	"seed":              {},
+	"repeat_penalty":    {},
+	"presence_penalty":  {},
+	"frequency_penalty": {},
+}
+
+// parsedModelfile holds the instructions of an Ollama Modelfile.
+type parsedModelfile struct {
+	From       string
+	System     *string
+	Template   *string
+	Parameters map[string]interface{}
+	Messages   []modelMessage
+}
+
+// parseModelfile parses the FROM, SYSTEM, TEMPLATE, PARAMETER and MESSAGE
+// instructions of a Modelfile. Values can be quoted, and span several lines
+// in triple quotes.
+func parseModelfile(text string) (*parsedModelfile, error) {
+	modelfile := &parsedModelfile{Parameters: make(map[string]interface{})}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		instruction, args, _ := strings.Cut(line, " ")
+		args = strings.TrimSpace(args)
+
+		// PARAMETER and MESSAGE take a name before their value
+		var name string
+		switch strings.ToUpper(instruction) {
+		case "PARAMETER", "MESSAGE":
+			name, args, _ = strings.Cut(args, " ")
+			args = strings.TrimSpace(args)
+		}
+		value, err := modelfileValue(args, scanner, &number)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+
+		switch strings.ToUpper(instruction) {
+		case "FROM":
+			modelfile.From = value
+		case "SYSTEM":
+			modelfile.System = &value
+		case "TEMPLATE":
+			modelfile.Template = &value
+		case "PARAMETER":
+			if err := modelfile.setParameter(name, value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", number, err)
+			}
+		case "MESSAGE":
+			switch name {
+			case "system", "user", "assistant":
+			default:
+				return nil, fmt.Errorf("line %d: invalid message role %q", number, name)
+			}
+			modelfile.Messages = append(modelfile.Messages, modelMessage{Role: name, Content: value})
+		case "LICENSE":
+			// Nothing to license, the model stays upstream
+		case "ADAPTER":
+			return nil, fmt.Errorf("line %d: adapters are not supported for remote models", number)
+		default:
+			return nil, fmt.Errorf("line %d: unknown instruction %q", number, instruction)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return modelfile, nil
+}
+
+// modelfileValue returns the value of an instruction, reading further lines for
+// values in triple quotes.
+func modelfileValue(args string, scanner *bufio.Scanner, number *int) (string, error) {
+	if !strings.HasPrefix(args, `"""`) {
+		if len(args) >= 2 && strings.HasPrefix(args, `"`) && strings.HasSuffix(args, `"`) {
+			if unquoted, err := strconv.Unquote(args); err == nil {
+				return unquoted, nil
+			}
+			return args[1 : len(args)-1], nil
+		}
+		return args, nil
+	}
+
+	value := args[3:]
+	for {
+		if end := strings.Index(value, `"""`); end >= 0 {
+			return value[:end], nil
+		}
+		if !scanner.Scan() {
+			return "", errors.New(`missing closing """`)
+		}
+		*number++
+		value += "\n" + scanner.Text()
+	}
+}
+
+// setParameter sets a PARAMETER. "stop" can be given several times.
+func (m *parsedModelfile) setParameter(name string, value string) error {
+	parsed, err := modelfileParameter(name, value)
+	if err != nil {
+		return err
+	}
+	if name == "stop" {
+		stops, _ := m.Parameters["stop"].([]interface{})
+		m.Parameters["stop"] = append(stops, parsed)
+		return nil
+	}
+	m.Parameters[name] = parsed
+	return nil
+}
+
+// modelfileParameter converts a parameter value to the type of the option.
+func modelfileParameter(name string, value string) (interface{}, error) {
+	if _, ok := modelfileParameters[name]; !ok {
+		if _, ignored := unsupportedOllamaOptions[name]; !ignored {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	if value == "" {
+		return nil, fmt.Errorf("parameter %q needs a value", name)
+	}
+	if name == "stop" {
+		return value, nil
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number, nil
+	}
+	if boolean, err := strconv.ParseBool(value); err == nil {
+		return boolean, nil
+	}
+	return nil, fmt.Errorf("invalid value %q for parameter %q", value, name)
+}
+
+// validateParameters checks the parameters of an /api/create request.
+func validateParameters(parameters map[string]interface{}) error {
+	for name, value := range parameters {
+		if _, ok := modelfileParameters[name]; !ok {
+			if _, ignored := unsupportedOllamaOptions[name]; !ignored {
+				return fmt.Errorf("unknown parameter %q", name)
+			}
+		}
+		if name == "stop" {
+			if _, err := optionStrings(value); err != nil {
+				return fmt.Errorf("parameter \"stop\": %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// parameterLines returns options as "name value" lines, like Ollama's /api/show.
+func parameterLines(options map[string]interface{}) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		values := []interface{}{options[name]}
+		if list, ok := options[name].([]interface{}); ok {
+			values = list
+		} else if list, ok := options[name].([]string); ok {
+			values = values[:0]
+			for _, value := range list {
+				values = append(values, value)
+			}
+		}
+		for _, value := range values {
+			lines = append(lines, name+" "+formatParameter(value))
+		}
+	}
+	return lines
+}
+
+func formatParameter(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// modelfileText quotes a value for a Modelfile, using triple quotes if needed.
+func modelfileText(value string) string {
+	if strings.ContainsAny(value, "\n\"") || value != strings.TrimSpace(value) {
+		return `"""` + value + `"""`
+	}
+	return value
+}
+
+// modelfile reconstructs the Modelfile of an alias or created model.
+func (a *modelAlias) modelfile() string {
+	var b strings.Builder
+	b.WriteString("# Modelfile generated by ollama-proxy\n")
+	b.WriteString("FROM " + a.Model + "\n")
+	if a.Template != "" {
+		b.WriteString("TEMPLATE " + modelfileText(a.Template) + "\n")
+	}
+	if a.System != "" {
+		b.WriteString("SYSTEM " + modelfileText(a.System) + "\n")
+	}
+	for _, line := range parameterLines(a.options()) {
+		b.WriteString("PARAMETER " + line + "\n")
+	}
+	for _, message := range a.Messages {
+		b.WriteString("MESSAGE " + message.Role + " " + modelfileText(message.Content) + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// openAISamplingParams are the sampling parameters of OpenAI chat and completion requests.
+// They are converted to Ollama options, so every backend maps them the same way.
+type openAISamplingParams struct {
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	MaxTokens           *int            `json:"max_tokens"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"`
+	Stop                json.RawMessage `json:"stop"`
+	Seed                *int            `json:"seed"`
+	PresencePenalty     *float64        `json:"presence_penalty"`
+	FrequencyPenalty    *float64        `json:"frequency_penalty"`
+	// Common extensions of OpenAI-compatible servers
+	TopK              *int     `json:"top_k"`
+	MinP              *float64 `json:"min_p"`
+	RepetitionPenalty *float64 `json:"repetition_penalty"`
+}
+
+// options converts the sampling parameters to Ollama options.
+func (p openAISamplingParams) options() (map[string]interface{}, error) {
+	options := make(map[string]interface{})
+	if p.Temperature != nil {
+		options["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		options["top_p"] = *p.TopP
+	}
+	if p.MaxCompletionTokens != nil {
+		options["num_predict"] = *p.MaxCompletionTokens
+	} else if p.MaxTokens != nil {
+		options["num_predict"] = *p.MaxTokens
+	}
+	if stop := bytes.TrimSpace(p.Stop); len(stop) > 0 && string(stop) != "null" {
+		var value interface{}
+		if err := json.Unmarshal(stop, &value); err != nil {
+			return nil, fmt.Errorf("invalid stop: %w", err)
+		}
+		if _, err := optionStrings(value); err != nil {
+			return nil, fmt.Errorf("invalid stop: %w", err)
+		}
+		options["stop"] = value
+	}
+	if p.Seed != nil {
+		options["seed"] = *p.Seed
+	}
+	if p.PresencePenalty != nil {
+		options["presence_penalty"] = *p.PresencePenalty
+	}
+	if p.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *p.FrequencyPenalty
+	}
+	if p.TopK != nil {
+		options["top_k"] = *p.TopK
+	}
+	if p.MinP != nil {
+		options["min_p"] = *p.MinP
+	}
+	if p.RepetitionPenalty != nil {
+		options["repeat_penalty"] = *p.RepetitionPenalty
+	}
+	return options, nil
+}
+
+// openAIResponseFormat is the "response_format" of a chat request. go-openai cannot
+// decode it, as the schema is an interface in its own type.
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Schema      json.RawMessage `json:"schema"`
+		Strict      bool            `json:"strict"`
+	} `json:"json_schema,omitempty"`
+}
+
+// responseFormat converts the response format to the request option.
+func (f *openAIResponseFormat) responseFormat() (*openai.ChatCompletionResponseFormat, error) {
+	if f == nil {
+		return nil, nil
+	}
+	switch openai.ChatCompletionResponseFormatType(f.Type) {
+	case "", openai.ChatCompletionResponseFormatTypeText:
+		return nil, nil
+	case openai.ChatCompletionResponseFormatTypeJSONObject:
+		return &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}, nil
+	case openai.ChatCompletionResponseFormatTypeJSONSchema:
+		if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+			return nil, errors.New("response_format of type json_schema requires json_schema.schema")
+		}
+		name := f.JSONSchema.Name
+		if name == "" {
+			name = "response"
+		}
+		return &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        name,
+				Description: f.JSONSchema.Description,
+				Schema:      f.JSONSchema.Schema,
+				Strict:      f.JSONSchema.Strict,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported response_format type %q", f.Type)
+	}
+}
+
+type openAIChatRequest struct {
+	Model          string                         `json:"model"`
+	Messages       []openai.ChatCompletionMessage `json:"messages"`
+	Stream         bool                           `json:"stream"`
+	StreamOptions  *openai.StreamOptions          `json:"stream_options,omitempty"`
+	Tools          []openai.Tool                  `json:"tools,omitempty"`
+	ResponseFormat *openAIResponseFormat          `json:"response_format,omitempty"`
+	openAISamplingParams
+}
+
+type openAICompletionRequest struct {
+	Model         string                `json:"model"`
+	Prompt        json.RawMessage       `json:"prompt"`
+	Suffix        string                `json:"suffix,omitempty"`
+	Echo          bool                  `json:"echo,omitempty"`
+	Stream        bool                  `json:"stream"`
+	StreamOptions *openai.StreamOptions `json:"stream_options,omitempty"`
+	openAISamplingParams
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string      `json:"model"`
+	Input          interface{} `json:"input"`
+	EncodingFormat string      `json:"encoding_format,omitempty"`
+	Dimensions     int         `json:"dimensions,omitempty"`
+	User           string      `json:"user,omitempty"`
+}
+
+// completionPrompt returns the prompt of a completion request. Batches of prompts
+// are not supported, as every prompt is a separate request upstream.
+func completionPrompt(raw json.RawMessage) (string, error) {
+	var prompt string
+	if err := json.Unmarshal(raw, &prompt); err == nil {
+		return prompt, nil
+	}
+	var prompts []string
+	if err := json.Unmarshal(raw, &prompts); err == nil {
+		if len(prompts) == 1 {
+			return prompts[0], nil
+		}
+		return "", errors.New("exactly one prompt is supported per request")
+	}
+	return "", errors.New("prompt must be a string")
+}
+
+// newResponseID returns a random ID for responses the upstream sent without one.
+func newResponseID(prefix string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return prefix + hex.EncodeToString(buf)
+}
+
+// openAIErrorType returns the OpenAI error type for an HTTP status.
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "api_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+func openAIErrorBody(status int, message string) gin.H {
+	return gin.H{"error": gin.H{
+		"message": message,
+		"type":    openAIErrorType(status),
+		"code":    nil,
+	}}
+}
+
+// openAIError answers with an error in the format of the OpenAI API.
+func openAIError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, openAIErrorBody(status, message))
+}
+
+// handleOpenAIError is handleUpstreamError for the OpenAI API. Client errors of the
+// upstream keep their status, so OpenAI clients can react to them.
+func handleOpenAIError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Info("Client cancelled request, upstream request aborted", "path", c.FullPath())
+		c.Abort()
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("Upstream request timed out", "path", c.FullPath(), "Error", err)
+		openAIError(c, http.StatusGatewayTimeout, "upstream request timed out")
+	case errors.Is(err, errEmbeddingsNotSupported), errors.Is(err, errCompletionsNotSupported):
+		openAIError(c, http.StatusNotImplemented, err.Error())
+	default:
+		slog.Error(message, "Error", err)
+		status := http.StatusInternalServerError
+		if code := upstreamStatusCode(err); code >= 400 && code < 500 {
+			status = code
+		}
+		openAIError(c, status, err.Error())
+	}
+}
+
+// resolveOpenAIModel maps the requested model to its full name, answering the
+// client itself if that fails.
+func resolveOpenAIModel(c *gin.Context, ctx context.Context, provider Provider, model string) (string, *modelAlias, bool) {
+	if model == "" {
+		openAIError(c, http.StatusBadRequest, "model is required")
+		return "", nil, false
+	}
+	slog.Info("Requested model", "model", model)
+	fullModelName, alias, err := resolveChatModel(ctx, provider, model)
+	if err != nil {
+		slog.Error("Error getting full model name", "Error", err, "model", model)
+		openAIError(c, modelErrorStatus(err), err.Error())
+		return "", nil, false
+	}
+	slog.Info("Using model", "fullModelName", fullModelName)
+	return fullModelName, alias, true
+}
+
+// sseWriter sends server-sent events the way the OpenAI API streams responses.
+type sseWriter struct {
+	w       gin.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEWriter(c *gin.Context) (*sseWriter, bool) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		slog.Error("Expected http.ResponseWriter to be an http.Flusher")
+		return nil, false
+	}
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	return &sseWriter{w: c.Writer, flusher: flusher}, true
+}
+
+func (s *sseWriter) Send(data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", payload)
+	s.flusher.Flush()
+	return nil
+}
+
+// Done ends the stream.
+func (s *sseWriter) Done() {
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+	s.flusher.Flush()
+}
+
+// Error reports an error that ended the upstream stream, if the client is still there.
+func (s *sseWriter) Error(err error) {
+	if !logStreamError(err) {
+		return
+	}
+	s.Send(openAIErrorBody(http.StatusInternalServerError, "Stream error: "+err.Error()))
+}
+
+// openAIModel converts a model of the model list to an OpenAI model object.
+func openAIModel(m Model) gin.H {
+	created := time.Now().Unix()
+	if modifiedAt, err := time.Parse(time.RFC3339, m.ModifiedAt); err == nil {
+		created = modifiedAt.Unix()
+	}
+	return gin.H{
+		"id":       m.Name,
+		"object":   "model",
+		"created":  created,
+		"owned_by": "library",
+	}
+}
+
+// encodeEmbeddingBase64 encodes an embedding as little-endian float32 values, as
+// the OpenAI API does for encoding_format "base64".
+func encodeEmbeddingBase64(embedding []float32) string {
+	buf := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(value))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// registerOpenAIRoutes adds the OpenAI-compatible API under /v1. It uses the same
+// provider, model filter and model resolution as the Ollama API.
+func registerOpenAIRoutes(r *gin.Engine, provider Provider, embeddings *EmbeddingService) {
+	r.GET("/v1/models", func(c *gin.Context) {
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		models, err := provider.GetModels(ctx)
+		if err != nil {
+			handleOpenAIError(c, "Error getting models", err)
+			return
+		}
+		key := requestKey(ctx)
+		data := make([]gin.H, 0, len(models))
+		for _, m := range models {
+			if modelAllowed(m) && key.listed(m) {
+				data = append(data, openAIModel(m))
+			}
+		}
+		for _, m := range append(aliasModels(models), embeddings.Models()...) {
+			if key.listed(m) {
+				data = append(data, openAIModel(m))
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+	})
+
+	// Model IDs may contain slashes, e.g. "deepseek/deepseek-chat"
+	r.GET("/v1/models/*model", func(c *gin.Context) {
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		name := strings.TrimPrefix(c.Param("model"), "/")
+		models, err := provider.GetModels(ctx)
+		if err != nil {
+			handleOpenAIError(c, "Error getting models", err)
+			return
+		}
+		key := requestKey(ctx)
+		for _, m := range models {
+			if (m.Name == name || m.Model == name) && modelAllowed(m) && key.listed(m) {
+				c.JSON(http.StatusOK, openAIModel(m))
+				return
+			}
+		}
+		for _, m := range append(aliasModels(models), embeddings.Models()...) {
+			if m.Name == name && key.listed(m) {
+				c.JSON(http.StatusOK, openAIModel(m))
+				return
+			}
+		}
+		openAIError(c, http.StatusNotFound, fmt.Sprintf("model %q not found", name))
+	})
+
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		var request openAIChatRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			openAIError(c, http.StatusBadRequest, "Invalid JSON payload: "+err.Error())
+			return
+		}
+		if len(request.Messages) == 0 {
+			openAIError(c, http.StatusBadRequest, "messages must not be empty")
+			return
+		}
+
+		options, err := request.options()
+		if err != nil {
+			openAIError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		format, err := request.ResponseFormat.responseFormat()
+		if err != nil {
+			openAIError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts := RequestOptions{
+			Tools:   request.Tools,
+			Options: options,
+			Format:  format,
+		}
+
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		fullModelName, alias, ok := resolveOpenAIModel(c, ctx, provider, request.Model)
+		if !ok {
+			return
+		}
+		alias.applyDefaults(&opts)
+		request.Messages = alias.chatMessages(request.Messages)
+
+		thinking := newOpenAIThinkingParser()
+		if !request.Stream {
+			response, err := provider.Chat(ctx, request.Messages, fullModelName, opts)
+			if err != nil {
+				handleOpenAIError(c, "Failed to get chat response", err)
+				return
+			}
+			loadedModels.Touch(ctx, provider, request.Model, fullModelName, nil)
+			for i := range response.Choices {
+				reasoning := ""
+				if i == 0 {
+					reasoning = response.Reasoning
+				}
+				_, response.Choices[i].Message.Content = newOpenAIThinkingParser().Split(reasoning, response.Choices[i].Message.Content)
+			}
+			if response.ID == "" {
+				response.ID = newResponseID("chatcmpl-")
+			}
+			if response.Model == "" {
+				response.Model = fullModelName
+			}
+			response.Object = "chat.completion"
+			if response.Created == 0 {
+				response.Created = time.Now().Unix()
+			}
+			c.JSON(http.StatusOK, response.ChatCompletionResponse)
+			return
+		}
+
+		stream, err := provider.ChatStream(ctx, request.Messages, fullModelName, opts)
+		if err != nil {
+			handleOpenAIError(c, "Failed to create stream", err)
+			return
+		}
+		loadedModels.Touch(ctx, provider, request.Model, fullModelName, nil)
+		defer stream.Close()
+
+		events, ok := newSSEWriter(c)
+		if !ok {
+			return
+		}
+
+		// Upstreams always report usage; it is only passed on if the client asked for it
+		includeUsage := request.StreamOptions != nil && request.StreamOptions.IncludeUsage
+		id := newResponseID("chatcmpl-")
+		created := time.Now().Unix()
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				events.Error(err)
+				return
+			}
+			if !includeUsage {
+				chunk.Usage = nil
+			}
+			if len(chunk.Choices) > 0 {
+				choice := &chunk.Choices[0]
+				received := choice.Delta.Content
+				_, choice.Delta.Content = thinking.Add(chunk.Reasoning, received)
+				if choice.FinishReason != "" {
+					_, rest := thinking.Flush()
+					choice.Delta.Content += rest
+				}
+				if (received != "" || chunk.Reasoning != "") && choice.Delta.Content == "" && choice.Delta.Role == "" && choice.FinishReason == "" && len(choice.Delta.ToolCalls) == 0 && chunk.Usage == nil {
+					// Only reasoning, or part of a tag
+					continue
+				}
+			}
+			if len(chunk.Choices) == 0 && chunk.Usage == nil {
+				continue
+			}
+
+			if chunk.ID == "" {
+				chunk.ID = id
+			}
+			if chunk.Model == "" {
+				chunk.Model = fullModelName
+			}
+			chunk.Object = "chat.completion.chunk"
+			chunk.Created = created
+			if err := events.Send(chunk.ChatCompletionStreamResponse); err != nil {
+				slog.Error("Error marshaling stream chunk", "Error", err)
+				return
+			}
+		}
+		events.Done()
+	})
+
+	r.POST("/v1/completions", func(c *gin.Context) {
+		var request openAICompletionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			openAIError(c, http.StatusBadRequest, "Invalid JSON payload: "+err.Error())
+			return
+		}
+		prompt, err := completionPrompt(request.Prompt)
+		if err != nil {
+			openAIError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		options, err := request.options()
+		if err != nil {
+			openAIError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts := RequestOptions{Options: options}
+
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		fullModelName, alias, ok := resolveOpenAIModel(c, ctx, provider, request.Model)
+		if !ok {
+			return
+		}
+		alias.applyDefaults(&opts)
+
+		// Text is added in front of the completion when the client asks to echo the prompt
+		echo := ""
+		if request.Echo {
+			echo = prompt
+		}
+
+		// Completions are raw text completions, like those of /api/generate with raw,
+		// so no chat template or system prompt is applied. With a suffix, the request
+		// is a fill-in-the-middle completion.
+		infillPrompt, suffix := fimPrompt(fullModelName, prompt, request.Suffix)
+
+		if !request.Stream {
+			response, err := complete(ctx, provider, infillPrompt, suffix, fullModelName, opts)
+			if err != nil {
+				handleOpenAIError(c, "Failed to get completion response", err)
+				return
+			}
+			loadedModels.Touch(ctx, provider, request.Model, fullModelName, nil)
+			if len(response.Choices) == 0 {
+				openAIError(c, http.StatusInternalServerError, "No response from model")
+				return
+			}
+			model := response.Model
+			if model == "" {
+				model = fullModelName
+			}
+			_, text := newOpenAIThinkingParser().Split(response.Reasoning, response.Choices[0].Message.Content)
+			c.JSON(http.StatusOK, openai.CompletionResponse{
+				ID:      newResponseID("cmpl-"),
+				Object:  "text_completion",
+				Created: time.Now().Unix(),
+				Model:   model,
+				Choices: []openai.CompletionChoice{{
+					Text:         echo + text,
+					FinishReason: string(response.Choices[0].FinishReason),
+				}},
+				Usage: response.Usage,
+			})
+			return
+		}
+
+		stream, err := completeStream(ctx, provider, infillPrompt, suffix, fullModelName, opts)
+		if err != nil {
+			handleOpenAIError(c, "Failed to create completion stream", err)
+			return
+		}
+		loadedModels.Touch(ctx, provider, request.Model, fullModelName, nil)
+		defer stream.Close()
+
+		events, ok := newSSEWriter(c)
+		if !ok {
+			return
+		}
+
+		includeUsage := request.StreamOptions != nil && request.StreamOptions.IncludeUsage
+		id := newResponseID("cmpl-")
+		created := time.Now().Unix()
+		model := fullModelName
+		// chunk builds a completion chunk; finish_reason is null until the last one
+		chunk := func(text string, finishReason openai.FinishReason, usage *openai.Usage) gin.H {
+			choices := []gin.H{}
+			if usage == nil {
+				choices = append(choices, gin.H{
+					"text":          text,
+					"index":         0,
+					"logprobs":      nil,
+					"finish_reason": finishReason,
+				})
+			}
+			event := gin.H{
+				"id":      id,
+				"object":  "text_completion",
+				"created": created,
+				"model":   model,
+				"choices": choices,
+			}
+			if usage != nil {
+				event["usage"] = usage
+			}
+			return event
+		}
+
+		if echo != "" {
+			if err := events.Send(chunk(echo, "", nil)); err != nil {
+				slog.Error("Error marshaling stream chunk", "Error", err)
+				return
+			}
+		}
+		var usage *openai.Usage
+		thinking := newOpenAIThinkingParser()
+		for {
+			response, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				events.Error(err)
+				return
+			}
+			if response.Model != "" {
+				model = response.Model
+			}
+			if response.Usage != nil {
+				usage = response.Usage
+			}
+			if len(response.Choices) == 0 {
+				continue
+			}
+
+			choice := response.Choices[0]
+			_, text := thinking.Add(response.Reasoning, choice.Delta.Content)
+			if choice.FinishReason != "" {
+				_, rest := thinking.Flush()
+				text += rest
+			}
+			if text == "" && choice.FinishReason == "" {
+				continue
+			}
+			if err := events.Send(chunk(text, choice.FinishReason, nil)); err != nil {
+				slog.Error("Error marshaling stream chunk", "Error", err)
+				return
+			}
+		}
+		if includeUsage && usage != nil {
+			if err := events.Send(chunk("", "", usage)); err != nil {
+				slog.Error("Error marshaling stream chunk", "Error", err)
+				return
+			}
+		}
+		events.Done()
+	})
+
+	r.POST("/v1/embeddings", func(c *gin.Context) {
+		var request openAIEmbeddingRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			openAIError(c, http.StatusBadRequest, "Invalid JSON payload: "+err.Error())
+			return
+		}
+		if request.Input == nil {
+			openAIError(c, http.StatusBadRequest, "input is required")
+			return
+		}
+		if request.EncodingFormat != "" && request.EncodingFormat != "float" && request.EncodingFormat != "base64" {
+			openAIError(c, http.StatusBadRequest, fmt.Sprintf("unsupported encoding_format %q", request.EncodingFormat))
+			return
+		}
+
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		if request.Model == "" {
+			openAIError(c, http.StatusBadRequest, "model is required")
+			return
+		}
+		fullModelName, err := embeddings.ResolveModel(ctx, request.Model)
+		if err != nil {
+			slog.Error("Error getting full model name", "Error", err, "model", request.Model)
+			openAIError(c, modelErrorStatus(err), err.Error())
+			return
+		}
+
+		// Embeddings are always fetched as floats and encoded here if needed.
+		// Like the OpenAI API, inputs that are too long are an error.
+		response, err := embeddings.Create(ctx, openai.EmbeddingRequest{
+			Input:          request.Input,
+			Model:          openai.EmbeddingModel(fullModelName),
+			User:           request.User,
+			EncodingFormat: openai.EmbeddingEncodingFormatFloat,
+			Dimensions:     request.Dimensions,
+		}, false)
+		if err != nil {
+			handleOpenAIError(c, "Failed to create embeddings", err)
+			return
+		}
+
+		data := make([]gin.H, 0, len(response.Data))
+		for _, embedding := range response.Data {
+			var value interface{} = embedding.Embedding
+			if request.EncodingFormat == "base64" {
+				value = encodeEmbeddingBase64(embedding.Embedding)
+			}
+			data = append(data, gin.H{"object": "embedding", "embedding": value, "index": embedding.Index})
+		}
+		model := string(response.Model)
+		if model == "" {
+			model = fullModelName
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"data":   data,
+			"model":  model,
+			"usage": gin.H{
+				"prompt_tokens": response.Usage.PromptTokens,
+				"total_tokens":  response.Usage.TotalTokens,
+			},
+		})
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errModelNotFound is returned for models the upstream does not know.
+var errModelNotFound = errors.New("model not found")
+
+// openrouterModel is a model of OpenRouter's /models catalog.
+type openrouterModel struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Created       int64  `json:"created"`
+	ContextLength int    `json:"context_length"`
+	Architecture  struct {
+		Modality         string   `json:"modality"`
+		InputModalities  []string `json:"input_modalities"`
+		OutputModalities []string `json:"output_modalities"`
+		Tokenizer        string   `json:"tokenizer"`
+		InstructType     string   `json:"instruct_type"`
+	} `json:"architecture"`
+	Pricing     map[string]interface{} `json:"pricing"` // USD per token, request, image, ...
+	TopProvider struct {
+		ContextLength       int  `json:"context_length"`
+		MaxCompletionTokens int  `json:"max_completion_tokens"`
+		IsModerated         bool `json:"is_moderated"`
+	} `json:"top_provider"`
+	SupportedParameters []string               `json:"supported_parameters"`
+	DefaultParameters   map[string]interface{} `json:"default_parameters"`
+}
+
+// fetchOpenrouterModels fetches the full model catalog, which has far more metadata
+// than go-openai's model list.
+func (o *OpenrouterProvider) fetchOpenrouterModels(ctx context.Context) ([]openrouterModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.modelsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+
+	resp, err := o.modelsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamHTTPError(resp)
+	}
+
+	var catalog struct {
+		Data []openrouterModel `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("failed to decode OpenRouter models: %w", err)
+	}
+	return catalog.Data, nil
+}
+
+// vendor returns the vendor part of the model ID, e.g. "openai" for "openai/gpt-4o".
+func (m *openrouterModel) vendor() string {
+	vendor, _, found := strings.Cut(m.ID, "/")
+	if !found {
+		return ""
+	}
+	return vendor
+}
+
+// family returns the model family, based on the tokenizer OpenRouter reports.
+func (m *openrouterModel) family() string {
+	tokenizer := strings.ToLower(m.Architecture.Tokenizer)
+	if tokenizer == "" || tokenizer == "other" || tokenizer == "router" {
+		if vendor := m.vendor(); vendor != "" {
+			return vendor
+		}
+		return "unknown"
+	}
+	return tokenizer
+}
+
+// contextLength returns the context window, preferring the limit of the provider OpenRouter uses.
+func (m *openrouterModel) contextLength() int {
+	if m.TopProvider.ContextLength > 0 {
+		return m.TopProvider.ContextLength
+	}
+	return m.ContextLength
+}
+
+// price returns a price of the model in USD per token (or per request, image, ...).
+// Unknown and variable prices, which OpenRouter reports as negative, are not ok.
+func (m *openrouterModel) price(key string) (float64, bool) {
+	var price float64
+	switch v := m.Pricing[key].(type) {
+	case string:
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, false
+		}
+		price = parsed
+	case float64:
+		price = v
+	default:
+		return 0, false
+	}
+	return price, price >= 0
+}
+
+func (m *openrouterModel) supports(parameter string) bool {
+	return containsString(m.SupportedParameters, parameter)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilities returns the Ollama capabilities of the model.
+func (m *openrouterModel) capabilities() []string {
+	if containsString(m.Architecture.OutputModalities, "embeddings") {
+		return []string{"embedding"}
+	}
+	capabilities := []string{"completion"}
+	if m.supports("tools") {
+		capabilities = append(capabilities, "tools")
+	}
+	if containsString(m.Architecture.InputModalities, "image") || strings.Contains(m.Architecture.Modality, "image->") ||
+		strings.Contains(m.Architecture.Modality, "+image") {
+		capabilities = append(capabilities, "vision")
+	}
+	if m.supports("reasoning") || m.supports("include_reasoning") {
+		capabilities = append(capabilities, "thinking")
+	}
+	return capabilities
+}
+
+// details returns the Ollama model details. OpenRouter models are served as an API,
+// so there is no parameter size or quantization to report.
+func (m *openrouterModel) details() ModelDetails {
+	family := m.family()
+	return ModelDetails{
+		Format:   "api",
+		Family:   family,
+		Families: []string{family},
+	}
+}
+
+// modelInfo returns the Ollama model_info, with the OpenRouter specific data under "openrouter.".
+func (m *openrouterModel) modelInfo() map[string]interface{} {
+	family := m.family()
+	info := map[string]interface{}{
+		"general.architecture":                          family,
+		"general.basename":                              m.Name,
+		"general.description":                           m.Description,
+		family + ".context_length":                      m.contextLength(),
+		"openrouter.id":                                 m.ID,
+		"openrouter.modality":                           m.Architecture.Modality,
+		"openrouter.input_modalities":                   m.Architecture.InputModalities,
+		"openrouter.output_modalities":                  m.Architecture.OutputModalities,
+		"openrouter.tokenizer":                          m.Architecture.Tokenizer,
+		"openrouter.context_length":                     m.ContextLength,
+		"openrouter.top_provider.context_length":        m.TopProvider.ContextLength,
+		"openrouter.top_provider.max_completion_tokens": m.TopProvider.MaxCompletionTokens,
+		"openrouter.top_provider.is_moderated":          m.TopProvider.IsModerated,
+		"openrouter.supported_parameters":               m.SupportedParameters,
+	}
+	if m.Architecture.InstructType != "" {
+		info["openrouter.instruct_type"] = m.Architecture.InstructType
+	}
+	for key, price := range m.Pricing {
+		info["openrouter.pricing."+key] = price
+	}
+	return info
+}
+
+// parameters returns the model parameters in the format of Ollama's /api/show,
+// one "name value" pair per line.
+func (m *openrouterModel) parameters() string {
+	lines := []string{fmt.Sprintf("num_ctx %d", m.contextLength())}
+	if m.TopProvider.MaxCompletionTokens > 0 {
+		lines = append(lines, fmt.Sprintf("num_predict %d", m.TopProvider.MaxCompletionTokens))
+	}
+
+	// Default sampling parameters of the model, under their Ollama names
+	names := map[string]string{
+		"temperature":        "temperature",
+		"top_p":              "top_p",
+		"top_k":              "top_k",
+		"min_p":              "min_p",
+		"repetition_penalty": "repeat_penalty",
+		"presence_penalty":   "presence_penalty",
+		"frequency_penalty":  "frequency_penalty",
+	}
+	var defaults []string
+	for key, value := range m.DefaultParameters {
+		name, ok := names[key]
+		if !ok || value == nil {
+			continue
+		}
+		if number, ok := value.(float64); ok {
+			defaults = append(defaults, name+" "+strconv.FormatFloat(number, 'f', -1, 64))
+		}
+	}
+	sort.Strings(defaults)
+	return strings.Join(append(lines, defaults...), "\n")
+}
+
+// modifiedAt returns the time the model was added to OpenRouter.
+func (m *openrouterModel) modifiedAt() string {
+	if m.Created > 0 {
+		return time.Unix(m.Created, 0).Format(time.RFC3339)
+	}
+	return time.Now().Format(time.RFC3339)
+}
+
+// show returns the model in the format of Ollama's /api/show response.
+func (m *openrouterModel) show() map[string]interface{} {
+	parameters := m.parameters()
+	modelfile := "# Modelfile generated by ollama-proxy\nFROM " + m.ID + "\n"
+	for _, line := range strings.Split(parameters, "\n") {
+		modelfile += "PARAMETER " + line + "\n"
+	}
+
+	return map[string]interface{}{
+		"modelfile":    modelfile,
+		"parameters":   parameters,
+		"template":     "{{ .Prompt }}",
+		"modified_at":  m.modifiedAt(),
+		"details":      m.details(),
+		"model_info":   m.modelInfo(),
+		"capabilities": m.capabilities(),
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// providerPreferences is OpenRouter's provider routing object from
+// OPENROUTER_PROVIDER, for the OpenRouter backends that do not set their own.
+var providerPreferences map[string]interface{}
+
+// loadProviderPreferences reads OPENROUTER_PROVIDER, a JSON object like
+// {"data_collection": "deny", "sort": "price"}.
+func loadProviderPreferences() error {
+	value := os.Getenv("OPENROUTER_PROVIDER")
+	if value == "" {
+		return nil
+	}
+	var preferences map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &preferences); err != nil {
+		return fmt.Errorf("invalid OPENROUTER_PROVIDER: %w", err)
+	}
+	if err := validateProviderPreferences(preferences); err != nil {
+		return fmt.Errorf("invalid OPENROUTER_PROVIDER: %w", err)
+	}
+	providerPreferences = preferences
+	return nil
+}
+
+// validateProviderPreferences checks the fields of a provider routing object that
+// OpenRouter documents. Other fields are passed on with a warning, as OpenRouter
+// may know them.
+func validateProviderPreferences(preferences map[string]interface{}) error {
+	for key, value := range preferences {
+		var err error
+		switch key {
+		case "order", "only", "ignore":
+			_, err = preferenceStrings(value)
+		case "quantizations":
+			var quantizations []string
+			if quantizations, err = preferenceStrings(value); err == nil {
+				for _, quantization := range quantizations {
+					switch quantization {
+					case "int4", "int8", "fp4", "fp6", "fp8", "fp16", "bf16", "fp32", "unknown":
+					default:
+						err = fmt.Errorf("unknown quantization %q", quantization)
+					}
+				}
+			}
+		case "allow_fallbacks", "require_parameters", "zdr":
+			if _, ok := value.(bool); !ok {
+				err = fmt.Errorf("expected a boolean, got %T", value)
+			}
+		case "data_collection":
+			if value != "allow" && value != "deny" {
+				err = fmt.Errorf("expected \"allow\" or \"deny\", got %v", value)
+			}
+		case "sort":
+			if value != "price" && value != "throughput" && value != "latency" {
+				err = fmt.Errorf("expected \"price\", \"throughput\" or \"latency\", got %v", value)
+			}
+		case "max_price":
+			prices, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("expected an object, got %T", value)
+				break
+			}
+			for name, price := range prices {
+				if _, priceErr := optionFloat(price); priceErr != nil {
+					err = fmt.Errorf("%s: %w", name, priceErr)
+				}
+			}
+		default:
+			slog.Warn("Unknown OpenRouter provider preference, passing it on", "preference", key)
+		}
+		if err != nil {
+			return fmt.Errorf("provider preference %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// preferenceStrings reads a list of strings.
+func preferenceStrings(value interface{}) ([]string, error) {
+	if _, ok := value.([]interface{}); !ok {
+		return nil, fmt.Errorf("expected a list of strings, got %T", value)
+	}
+	return optionStrings(value)
+}
+
+// preferencesOption returns the provider preferences of a request, from the
+// "openrouter" Ollama option: {"openrouter": {"provider": {...}}}. Invalid
+// preferences are ignored with a warning, like other invalid options.
+func preferencesOption(options map[string]interface{}) map[string]interface{} {
+	value, ok := options["openrouter"]
+	if !ok {
+		return nil
+	}
+	openrouter, ok := value.(map[string]interface{})
+	if !ok {
+		slog.Warn("Invalid value for Ollama option, ignoring", "option", "openrouter", "value", value)
+		return nil
+	}
+	var preferences map[string]interface{}
+	for key, value := range openrouter {
+		if key != "provider" {
+			slog.Warn("Unknown OpenRouter option, ignoring", "option", "openrouter."+key)
+			continue
+		}
+		provider, ok := value.(map[string]interface{})
+		if !ok {
+			slog.Warn("Invalid value for Ollama option, ignoring", "option", "openrouter.provider", "value", value)
+			continue
+		}
+		if err := validateProviderPreferences(provider); err != nil {
+			slog.Warn("Invalid value for Ollama option, ignoring", "option", "openrouter.provider", "Error", err)
+			continue
+		}
+		preferences = provider
+	}
+	return preferences
+}
+
+// mergeProviderPreferences returns the preferences with the fields of each override
+// replacing those before it. The arguments are not modified.
+func mergeProviderPreferences(preferences ...map[string]interface{}) map[string]interface{} {
+	var merged map[string]interface{}
+	for _, layer := range preferences {
+		for key, value := range layer {
+			if merged == nil {
+				merged = make(map[string]interface{})
+			}
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// routingVariants are the model ID suffixes that only change OpenRouter's provider
+// routing: ":nitro" prefers the fastest providers, ":floor" the cheapest.
+var routingVariants = []string{":nitro", ":floor"}
+
+// splitRoutingVariant returns the model ID without its routing variant, and the
+// variant.
+func splitRoutingVariant(id string) (string, string) {
+	for _, variant := range routingVariants {
+		if strings.HasSuffix(id, variant) {
+			return strings.TrimSuffix(id, variant), variant
+		}
+	}
+	return id, ""
+}
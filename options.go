@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// RequestOptions carries the optional, per-request settings that are forwarded
+// to the upstream model together with the messages.
+type RequestOptions struct {
+	Tools   []openai.Tool
+	Options map[string]interface{}               // Ollama "options" object
+	Format  *openai.ChatCompletionResponseFormat // Structured output requested via Ollama "format"
+
+	// Models the upstream may fall back to on its own, for backends that support it
+	Fallbacks []string
+
+	// OpenRouter provider routing preferences, sent as the "provider" object
+	Provider map[string]interface{}
+
+	// Reasoning settings from Ollama's "think", sent as the "reasoning" object
+	Reasoning map[string]interface{}
+}
+
+// Ollama options that only make sense for a locally running llama.cpp runner.
+// They are ignored with a warning, as there is nothing upstream to map them to.
+var unsupportedOllamaOptions = map[string]struct{}{
+	"num_ctx":          {},
+	"num_keep":         {},
+	"num_batch":        {},
+	"num_gpu":          {},
+	"main_gpu":         {},
+	"num_thread":       {},
+	"low_vram":         {},
+	"vocab_only":       {},
+	"use_mmap":         {},
+	"use_mlock":        {},
+	"numa":             {},
+	"typical_p":        {},
+	"repeat_last_n":    {},
+	"tfs_z":            {},
+	"mirostat":         {},
+	"mirostat_tau":     {},
+	"mirostat_eta":     {},
+	"penalize_newline": {},
+}
+
+// applyOllamaOptions maps Ollama's "options" onto the chat completion request.
+// Parameters that go-openai has no field for (top_k, min_p, repetition_penalty)
+// are returned separately so they can be merged into the request body.
+func applyOllamaOptions(req *openai.ChatCompletionRequest, options map[string]interface{}) map[string]interface{} {
+	extra := make(map[string]interface{})
+
+	for key, value := range options {
+		var err error
+		switch key {
+		case "temperature":
+			var temperature float64
+			if temperature, err = optionFloat(value); err == nil {
+				req.Temperature = float32(temperature)
+				if temperature == 0 {
+					// go-openai omits a zero temperature, so send it explicitly
+					extra["temperature"] = 0
+				}
+			}
+		case "top_p":
+			var topP float64
+			if topP, err = optionFloat(value); err == nil {
+				req.TopP = float32(topP)
+			}
+		case "top_k":
+			var topK int
+			if topK, err = optionInt(value); err == nil {
+				extra["top_k"] = topK
+			}
+		case "min_p":
+			var minP float64
+			if minP, err = optionFloat(value); err == nil {
+				extra["min_p"] = minP
+			}
+		case "num_predict":
+			var numPredict int
+			if numPredict, err = optionInt(value); err == nil && numPredict > 0 {
+				// -1 (infinite) and -2 (fill context) mean "no limit" upstream
+				req.MaxTokens = numPredict
+			}
+		case "stop":
+			req.Stop, err = optionStrings(value)
+		case "seed":
+			var seed int
+			if seed, err = optionInt(value); err == nil {
+				req.Seed = &seed
+			}
+		case "repeat_penalty":
+			var repeatPenalty float64
+			if repeatPenalty, err = optionFloat(value); err == nil {
+				extra["repetition_penalty"] = repeatPenalty
+			}
+		case "presence_penalty":
+			var presencePenalty float64
+			if presencePenalty, err = optionFloat(value); err == nil {
+				req.PresencePenalty = float32(presencePenalty)
+			}
+		case "frequency_penalty":
+			var frequencyPenalty float64
+			if frequencyPenalty, err = optionFloat(value); err == nil {
+				req.FrequencyPenalty = float32(frequencyPenalty)
+			}
+		case "openrouter":
+			// Provider routing preferences, sent by the OpenRouter provider
+		default:
+			if _, ok := unsupportedOllamaOptions[key]; ok {
+				slog.Warn("Ollama option has no OpenRouter equivalent, ignoring", "option", key, "value", value)
+			} else {
+				slog.Warn("Unknown Ollama option, ignoring", "option", key, "value", value)
+			}
+		}
+		if err != nil {
+			slog.Warn("Invalid value for Ollama option, ignoring", "option", key, "value", value, "Error", err)
+		}
+	}
+
+	return extra
+}
+
+func optionFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+}
+
+func optionInt(value interface{}) (int, error) {
+	f, err := optionFloat(value)
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) {
+		return 0, fmt.Errorf("expected an integer, got %v", f)
+	}
+	return int(f), nil
+}
+
+func optionStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings, got %T item", item)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("expected a string or a list of strings, got %T", value)
+	}
+}
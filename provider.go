@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
@@ -59,9 +63,87 @@ func formatImageForAPI(imgBase64 string) string {
 }
 
 type OpenrouterProvider struct {
-	client     *openai.Client
-	modelNames []string // Shared storage for model names
-	apiKey     string   // Store the API key
+	client        *openai.Client
+	apiKey        string                 // Store the API key
+	formatRetries int                    // Retries for output that does not match the requested format, 0 disables validation
+	modelsClient  *http.Client           // Client for the model catalog
+	modelsURL     string                 // OpenRouter's /models under the base URL, for the catalog
+	catalog       *modelCatalog          // Cached model catalog, nil for OpenAI-compatible backends
+	preferences   map[string]interface{} // Provider routing preferences of the backend
+	openrouter    bool                   // The upstream is OpenRouter, which understands the fields of openrouterFields
+}
+
+// openrouterFields are the request body fields that only OpenRouter understands.
+// Other OpenAI-compatible APIs may reject unknown fields, so they are not sent there.
+var openrouterFields = []string{"models", "provider", "reasoning", "usage", "top_k", "min_p", "repetition_penalty"}
+
+// upstreamExtra returns the extra body fields of a request as the upstream accepts them.
+func (o *OpenrouterProvider) upstreamExtra(extra map[string]interface{}, modelName string) map[string]interface{} {
+	if o.openrouter {
+		return extra
+	}
+	var dropped []string
+	for _, field := range openrouterFields {
+		if _, ok := extra[field]; ok {
+			delete(extra, field)
+			dropped = append(dropped, field)
+		}
+	}
+	if len(dropped) > 0 {
+		slog.Warn("OpenAI-compatible backend does not support these OpenRouter fields, ignoring them", "model", modelName, "fields", dropped)
+	}
+	return extra
+}
+
+// ChatCompletionStream is the stream of chunks returned by ChatStream and GenerateStream
+type ChatCompletionStream interface {
+	Recv() (ChatChunk, error)
+	Close() error
+}
+
+// responseStream replays a complete response as a single stream chunk
+type responseStream struct {
+	chunk *ChatChunk
+}
+
+func newResponseStream(resp ChatResponse) *responseStream {
+	chunk := ChatChunk{Reasoning: resp.Reasoning}
+	chunk.ID = resp.ID
+	chunk.Object = "chat.completion.chunk"
+	chunk.Created = resp.Created
+	chunk.Model = resp.Model
+	chunk.Usage = &resp.Usage
+	for _, choice := range resp.Choices {
+		toolCalls := make([]openai.ToolCall, len(choice.Message.ToolCalls))
+		for i, call := range choice.Message.ToolCalls {
+			index := i
+			call.Index = &index
+			toolCalls[i] = call
+		}
+		chunk.Choices = append(chunk.Choices, openai.ChatCompletionStreamChoice{
+			Index: choice.Index,
+			Delta: openai.ChatCompletionStreamChoiceDelta{
+				Role:      choice.Message.Role,
+				Content:   choice.Message.Content,
+				ToolCalls: toolCalls,
+			},
+			FinishReason: choice.FinishReason,
+		})
+	}
+	return &responseStream{chunk: &chunk}
+}
+
+func (s *responseStream) Recv() (ChatChunk, error) {
+	if s.chunk == nil {
+		return ChatChunk{}, io.EOF
+	}
+	chunk := *s.chunk
+	s.chunk = nil
+	return chunk, nil
+}
+
+func (s *responseStream) Close() error {
+	return nil
 }
 
 func NewOpenrouterProvider(apiKey string) *OpenrouterProvider {
@@ -94,7 +176,13 @@ func NewOpenrouterProvider(apiKey string) *OpenrouterProvider {
 	// Add custom headers for OpenRouter
 	config.HTTPClient = &http.Client{
 		Transport: &headerTransport{
-			base: http.DefaultTransport,
+			base: &extraBodyTransport{
+				base: &usageTransport{
+					base:          &responseCaptureTransport{base: http.DefaultTransport},
+					generationURL: strings.TrimSuffix(config.BaseURL, "/") + "/generation",
+					apiKey:        apiKey,
+				},
+			},
 			headers: map[string]string{
 				"HTTP-Referer": httpReferer,
 				"X-Title":      xTitle,
@@ -102,11 +190,44 @@ func NewOpenrouterProvider(apiKey string) *OpenrouterProvider {
 		},
 	}
 	
-	return &OpenrouterProvider{
-		client:     openai.NewClientWithConfig(config),
-		modelNames: []string{},
-		apiKey:     apiKey,
+	// Get structured output validation settings from environment variables
+	formatRetries := 0
+	if value := os.Getenv("FORMAT_VALIDATION_RETRIES"); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			slog.Error("Invalid FORMAT_VALIDATION_RETRIES, disabling format validation", "value", value)
+		} else {
+			formatRetries = retries
+			slog.Info("Validating structured outputs", "retries", formatRetries)
+		}
+	}
+
+	// Get the model catalog cache settings from environment variables
+	catalogTTL, err := loadCatalogTTL()
+	if err != nil {
+		slog.Error("Invalid MODELS_CACHE_TTL, using default", "Error", err, "default", defaultCatalogTTL)
+		catalogTTL = defaultCatalogTTL
+	}
+
+	provider := &OpenrouterProvider{
+		client:        openai.NewClientWithConfig(config),
+		apiKey:        apiKey,
+		formatRetries: formatRetries,
+		openrouter:    true,
+		modelsURL:     strings.TrimSuffix(config.BaseURL, "/") + "/models",
+		modelsClient: &http.Client{
+			Transport: &headerTransport{
+				base: http.DefaultTransport,
+				headers: map[string]string{
+					"HTTP-Referer": httpReferer,
+					"X-Title":      xTitle,
+				},
+			},
+		},
 	}
+	provider.catalog = newModelCatalog(provider.fetchOpenrouterModels, catalogTTL)
+	provider.catalog.Start()
+	return provider
 }
 
 // Custom transport to add headers to all requests
@@ -122,25 +243,181 @@ func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return t.base.RoundTrip(req)
 }
 
-func (o *OpenrouterProvider) Chat(messages []openai.ChatCompletionMessage, modelName string) (openai.ChatCompletionResponse, error) {
-	// Create a chat completion request
+type extraBodyKey struct{}
+
+// withExtraBody attaches request body fields that go-openai has no struct fields for.
+// They are merged into the outgoing JSON body by extraBodyTransport.
+func withExtraBody(ctx context.Context, extra map[string]interface{}) context.Context {
+	if len(extra) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, extraBodyKey{}, extra)
+}
+
+// Custom transport to merge extra fields from the request context into JSON bodies
+type extraBodyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *extraBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	extra, ok := req.Context().Value(extraBodyKey{}).(map[string]interface{})
+	if !ok || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	rawBody, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rawBody, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode request body: %w", err)
+	}
+	for key, value := range extra {
+		body[key] = value
+	}
+	rawBody, err = json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request body: %w", err)
+	}
+
+	newReq := req.Clone(req.Context())
+	newReq.Body = io.NopCloser(bytes.NewReader(rawBody))
+	newReq.ContentLength = int64(len(rawBody))
+	newReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(rawBody)), nil
+	}
+	return t.base.RoundTrip(newReq)
+}
+
+// newChatRequest builds a chat completion request and applies the request options to it.
+// The returned context carries the fields that have to be merged into the request body.
+func (o *OpenrouterProvider) newChatRequest(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, stream bool, opts RequestOptions) (openai.ChatCompletionRequest, context.Context) {
 	req := openai.ChatCompletionRequest{
 		Model:    modelName,
 		Messages: messages,
-		Stream:   false,
+		Stream:   stream,
+		Tools:    opts.Tools,
+	}
+	if opts.Format != nil {
+		req.ResponseFormat = opts.Format
+	}
+	if stream {
+		// Ask for token usage in the last chunk of the stream
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	extra := applyOllamaOptions(&req, opts.Options)
+	if len(opts.Fallbacks) > 0 {
+		// OpenRouter tries the models in order and reports the one that answered
+		extra["models"] = append([]string{modelName}, opts.Fallbacks...)
+	}
+	if preferences := o.providerRouting(opts); len(preferences) > 0 {
+		extra["provider"] = preferences
 	}
+	if len(opts.Reasoning) > 0 {
+		extra["reasoning"] = opts.Reasoning
+	}
+	if o.openrouter {
+		// Ask for the cost of each request, which only OpenRouter reports
+		extra["usage"] = map[string]interface{}{"include": true}
+	}
+	return req, withExtraBody(ctx, o.upstreamExtra(extra, modelName))
+}
+
+// providerRouting returns OpenRouter's provider object for a request: the backend's
+// preferences, overridden by those of the alias and then by the request's own.
+func (o *OpenrouterProvider) providerRouting(opts RequestOptions) map[string]interface{} {
+	return mergeProviderPreferences(o.preferences, opts.Provider, preferencesOption(opts.Options))
+}
+
+// createChatCompletion gets a complete response, with the reasoning of the model
+func (o *OpenrouterProvider) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (ChatResponse, error) {
+	var raw []byte
+	resp, err := o.client.CreateChatCompletion(withResponseCapture(ctx, &raw), req)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	return ChatResponse{ChatCompletionResponse: resp, Reasoning: responseReasoning(raw)}, nil
+}
+
+// SupportsNativeFallback reports that OpenRouter handles fallback models itself.
+func (o *OpenrouterProvider) SupportsNativeFallback(models []string) bool {
+	return true
+}
+
+func (o *OpenrouterProvider) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error) {
+	// Create a chat completion request
+	req, ctx := o.newChatRequest(ctx, messages, modelName, false, opts)
 
 	// Call the OpenAI API to get a complete response
-	resp, err := o.client.CreateChatCompletion(context.Background(), req)
+	resp, err := o.createChatCompletion(ctx, req)
 	if err != nil {
-		return openai.ChatCompletionResponse{}, err
+		return ChatResponse{}, err
+	}
+
+	// Make sure structured output matches the requested format
+	if o.validatesFormat(opts) {
+		return o.validateFormat(ctx, req, resp)
 	}
 
 	// Return the complete response
 	return resp, nil
 }
 
-func (o *OpenrouterProvider) ChatStream(messages []openai.ChatCompletionMessage, modelName string) (*openai.ChatCompletionStream, error) {
+// validatesFormat reports whether responses for these options are checked against the requested format
+func (o *OpenrouterProvider) validatesFormat(opts RequestOptions) bool {
+	return opts.Format != nil && o.formatRetries > 0
+}
+
+// validateFormat checks the response against the requested format and asks the model
+// to correct itself until it matches or the retries are used up. This covers models
+// that ignore response_format.
+func (o *OpenrouterProvider) validateFormat(ctx context.Context, req openai.ChatCompletionRequest, resp ChatResponse) (ChatResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if len(resp.Choices) == 0 {
+			return resp, nil
+		}
+
+		// Reasoning that the model writes in front of the output is kept, but not validated
+		thinking, answer := (&thinkingParser{}).Split("", resp.Choices[0].Message.Content)
+		if thinking != "" {
+			thinking = thinkOpen + thinking + thinkClose
+		}
+
+		// Models without structured output support often wrap JSON in markdown fences
+		content := stripCodeFence(answer)
+		validationErr := validateFormattedOutput(content, req.ResponseFormat)
+		if validationErr == nil {
+			resp.Choices[0].Message.Content = thinking + content
+			return resp, nil
+		}
+		if attempt == o.formatRetries {
+			slog.Warn("Output still does not match the requested format, returning it anyway",
+				"model", req.Model, "attempts", attempt+1, "Error", validationErr)
+			return resp, nil
+		}
+
+		slog.Warn("Output does not match the requested format, retrying",
+			"model", req.Model, "attempt", attempt+1, "Error", validationErr)
+		req.Messages = append(req.Messages,
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: answer,
+			},
+			formatRetryMessage(req.ResponseFormat, validationErr),
+		)
+
+		var err error
+		resp, err = o.createChatCompletion(ctx, req)
+		if err != nil {
+			return ChatResponse{}, err
+		}
+	}
+}
+
+func (o *OpenrouterProvider) ChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
 	// Log the messages being sent for debugging
 	slog.Info("Sending messages to OpenRouter", "messageCount", len(messages))
 	for i, msg := range messages {
@@ -167,25 +444,38 @@ func (o *OpenrouterProvider) ChatStream(messages []openai.ChatCompletionMessage,
 		}
 	}
 
-	// Create a chat completion request
-	req := openai.ChatCompletionRequest{
-		Model:    modelName,
-		Messages: messages,
-		Stream:   true,
+	// Validated output can only be sent once it is complete
+	if o.validatesFormat(opts) {
+		resp, err := o.Chat(ctx, messages, modelName, opts)
+		if err != nil {
+			return nil, err
+		}
+		return newResponseStream(resp), nil
 	}
 
+	// Create a chat completion request
+	req, ctx := o.newChatRequest(ctx, messages, modelName, true, opts)
+
 	// Call the OpenAI API to get a streaming response
-	stream, err := o.client.CreateChatCompletionStream(context.Background(), req)
+	stream, err := o.client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	// Return the stream for further processing
-	return stream, nil
+	// Return the stream for further processing, with the reasoning go-openai drops
+	return &reasoningStream{stream: stream}, nil
 }
 
-// Generate creates a completion (non-streaming) for a text prompt
-func (o *OpenrouterProvider) Generate(prompt string, modelName string, systemPrompt string, images []string) (openai.ChatCompletionResponse, error) {
+func (o *OpenrouterProvider) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error) {
+	// OpenAI-style APIs reject inputs that are too long, so shorten them here
+	if truncate {
+		req.Input = truncateEmbeddingInput(req.Input)
+	}
+	return o.client.CreateEmbeddings(ctx, req)
+}
+
+// buildGenerateMessages turns a generate request (prompt, system prompt and images) into chat messages
+func buildGenerateMessages(prompt string, systemPrompt string, images []string) []openai.ChatCompletionMessage {
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleUser,
@@ -225,7 +515,7 @@ func (o *OpenrouterProvider) Generate(prompt string, modelName string, systemPro
 			formattedURL := formatImageForAPI(imgBase64)
 			
 			// Log what we're sending
-			slog.Info("Adding image to Generate request", 
+			slog.Info("Adding image to generate request", 
 				"imageIndex", idx, 
 				"formattedUrlPrefix", formattedURL[:min(50, len(formattedURL))])
 			
@@ -244,106 +534,125 @@ func (o *OpenrouterProvider) Generate(prompt string, modelName string, systemPro
 			MultiContent: contentItems,
 		}
 		
-		slog.Info("Successfully prepared multimodal message for Generate", 
+		slog.Info("Successfully prepared multimodal message for generate request", 
 			"contentPartCount", len(contentItems))
 	}
 
-	// Create a chat completion request
-	req := openai.ChatCompletionRequest{
-		Model:    modelName,
-		Messages: messages,
-		Stream:   false,
-	}
+	return messages
+}
 
-	// Call the OpenAI API to get a complete response
-	resp, err := o.client.CreateChatCompletion(context.Background(), req)
-	if err != nil {
-		return openai.ChatCompletionResponse{}, err
-	}
+// Generate creates a completion (non-streaming) for a text prompt
+func (o *OpenrouterProvider) Generate(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatResponse, error) {
+	messages := buildGenerateMessages(prompt, systemPrompt, images)
 
-	// Return the complete response
-	return resp, nil
+	// Get the complete response the same way as for chat requests
+	return o.Chat(ctx, messages, modelName, opts)
 }
 
 // GenerateStream creates a streaming completion for a text prompt
-func (o *OpenrouterProvider) GenerateStream(prompt string, modelName string, systemPrompt string, images []string) (*openai.ChatCompletionStream, error) {
-	messages := []openai.ChatCompletionMessage{
-		{
-			Role:    openai.ChatMessageRoleUser,
-			Content: prompt,
-		},
-	}
+func (o *OpenrouterProvider) GenerateStream(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatCompletionStream, error) {
+	messages := buildGenerateMessages(prompt, systemPrompt, images)
 
-	// Add system message if provided
-	if systemPrompt != "" {
-		messages = append([]openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: systemPrompt,
-			},
-		}, messages...)
-	}
+	// Stream the response the same way as for chat requests
+	return o.ChatStream(ctx, messages, modelName, opts)
+}
 
-	// Add images if provided (for multimodal models)
-	if len(images) > 0 {
-		var contentItems []openai.ChatMessagePart
+// newCompletionRequest builds a text completion request, with the request options
+// applied the same way as for chat requests.
+func (o *OpenrouterProvider) newCompletionRequest(ctx context.Context, prompt string, suffix string, modelName string, stream bool, opts RequestOptions) (openai.CompletionRequest, context.Context) {
+	var chat openai.ChatCompletionRequest
+	extra := applyOllamaOptions(&chat, opts.Options)
+	req := openai.CompletionRequest{
+		Model:            modelName,
+		Prompt:           prompt,
+		Suffix:           suffix,
+		Stream:           stream,
+		MaxTokens:        chat.MaxTokens,
+		Temperature:      chat.Temperature,
+		TopP:             chat.TopP,
+		Stop:             chat.Stop,
+		Seed:             chat.Seed,
+		PresencePenalty:  chat.PresencePenalty,
+		FrequencyPenalty: chat.FrequencyPenalty,
+	}
+	if stream {
+		// Ask for token usage in the last chunk of the stream
+		extra["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+	if preferences := o.providerRouting(opts); len(preferences) > 0 {
+		extra["provider"] = preferences
+	}
+	if o.openrouter {
+		// Ask for the cost of each request, which only OpenRouter reports
+		extra["usage"] = map[string]interface{}{"include": true}
+	}
+	return req, withExtraBody(ctx, o.upstreamExtra(extra, modelName))
+}
 
-		// Add text content
-		contentItems = append(contentItems, openai.ChatMessagePart{
-			Type: openai.ChatMessagePartTypeText,
-			Text: prompt,
+// Complete runs a text completion, for raw prompts and fill-in-the-middle
+func (o *OpenrouterProvider) Complete(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatResponse, error) {
+	req, ctx := o.newCompletionRequest(ctx, prompt, suffix, modelName, false, opts)
+	resp, err := o.client.CreateCompletion(ctx, req)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	var result ChatResponse
+	result.ID = resp.ID
+	result.Object = "chat.completion"
+	result.Created = resp.Created
+	result.Model = resp.Model
+	result.Usage = resp.Usage
+	for _, choice := range resp.Choices {
+		result.Choices = append(result.Choices, openai.ChatCompletionChoice{
+			Index:        choice.Index,
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: choice.Text},
+			FinishReason: openai.FinishReason(choice.FinishReason),
 		})
-
-		// Add image contents with proper formatting
-		for idx, imgBase64 := range images {
-			// Ensure image isn't empty
-			if len(imgBase64) == 0 {
-				slog.Error("Empty image data received, skipping", "imageIndex", idx)
-				continue
-			}
-			
-			// Format the image URL correctly
-			formattedURL := formatImageForAPI(imgBase64)
-			
-			// Log what we're sending
-			slog.Info("Adding image to GenerateStream request", 
-				"imageIndex", idx, 
-				"formattedUrlPrefix", formattedURL[:min(50, len(formattedURL))])
-			
-			contentItems = append(contentItems, openai.ChatMessagePart{
-				Type: openai.ChatMessagePartTypeImageURL,
-				ImageURL: &openai.ChatMessageImageURL{
-					URL: formattedURL,
-				},
-			})
-		}
-
-		// Replace the user message with the one containing content parts
-		messages[len(messages)-1] = openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: "", // Will be ignored in favor of ContentParts
-			MultiContent: contentItems,
-		}
-		
-		slog.Info("Successfully prepared multimodal message for GenerateStream", 
-			"contentPartCount", len(contentItems))
 	}
+	return result, nil
+}
 
-	// Create a chat completion request
-	req := openai.ChatCompletionRequest{
-		Model:    modelName,
-		Messages: messages,
-		Stream:   true,
+// CompleteStream runs a streaming text completion
+func (o *OpenrouterProvider) CompleteStream(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	req, ctx := o.newCompletionRequest(ctx, prompt, suffix, modelName, true, opts)
+	stream, err := o.client.CreateCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
 	}
+	return &completionStream{stream: stream}, nil
+}
 
-	// Call the OpenAI API to get a streaming response
-	stream, err := o.client.CreateChatCompletionStream(context.Background(), req)
+// completionStream turns the chunks of a text completion stream into chat chunks
+type completionStream struct {
+	stream *openai.CompletionStream
+}
+
+func (s *completionStream) Recv() (ChatChunk, error) {
+	resp, err := s.stream.Recv()
 	if err != nil {
-		return nil, err
+		return ChatChunk{}, err
+	}
+	var chunk ChatChunk
+	chunk.ID = resp.ID
+	chunk.Object = "chat.completion.chunk"
+	chunk.Created = resp.Created
+	chunk.Model = resp.Model
+	if resp.Usage.TotalTokens > 0 {
+		usage := resp.Usage
+		chunk.Usage = &usage
+	}
+	for _, choice := range resp.Choices {
+		chunk.Choices = append(chunk.Choices, openai.ChatCompletionStreamChoice{
+			Index:        choice.Index,
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Content: choice.Text},
+			FinishReason: openai.FinishReason(choice.FinishReason),
+		})
 	}
+	return chunk, nil
+}
 
-	// Return the stream for further processing
-	return stream, nil
+func (s *completionStream) Close() error {
+	return s.stream.Close()
 }
 
 type ModelDetails struct {
@@ -362,123 +671,97 @@ type Model struct {
 	Size       int64        `json:"size,omitempty"`
 	Digest     string       `json:"digest,omitempty"`
 	Details    ModelDetails `json:"details,omitempty"`
-}
-
-func (o *OpenrouterProvider) GetModels() ([]Model, error) {
-	currentTime := time.Now().Format(time.RFC3339)
-
-	// Create a dedicated client config for GetModels to always use the specific OpenRouter API URL
-	modelsAPIConfig := openai.DefaultConfig(o.apiKey) // Use stored API key
-	modelsAPIConfig.BaseURL = "https://openrouter.ai/api/v1/" // Force this URL
 
-	// Get header values from environment variables (mirroring NewOpenrouterProvider logic)
-	httpReferer := os.Getenv("OPENROUTER_HTTP_REFERER")
-	// If OPENROUTER_HTTP_REFERER is not set, httpReferer will be "", which is the desired default.
+	id   string           // Full model ID, if the name differs from it
+	meta *openrouterModel // Catalog entry of OpenRouter models
+}
 
-	xTitle := os.Getenv("OPENROUTER_X_TITLE")
-	if xTitle == "" {
-		xTitle = "ollama-proxy" // Default value, consistent with NewOpenrouterProvider
+func (o *OpenrouterProvider) GetModels(ctx context.Context) ([]Model, error) {
+	catalog, err := o.catalog.Get(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add custom headers for OpenRouter
-	modelsAPIConfig.HTTPClient = &http.Client{
-		Transport: &headerTransport{
-			base: http.DefaultTransport,
-			headers: map[string]string{
-				"HTTP-Referer": httpReferer,
-				"X-Title":      xTitle,
-			},
-		},
+	names := shortModelNames(catalog.ids)
+	models := make([]Model, 0, len(catalog.models))
+	for i := range catalog.models {
+		apiModel := &catalog.models[i]
+		models = append(models, Model{
+			Name:       names[i],
+			Model:      names[i],
+			ModifiedAt: apiModel.modifiedAt(),
+			Size:       0, // Served remotely, nothing to download
+			Digest:     names[i],
+			Details:    apiModel.details(),
+			id:         apiModel.ID,
+			meta:       apiModel,
+		})
 	}
 
-	modelsClient := openai.NewClientWithConfig(modelsAPIConfig)
+	return models, nil
+}
 
-	// Fetch models from the OpenAI API
-	modelsResponse, err := modelsClient.ListModels(context.Background())
+func (o *OpenrouterProvider) GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error) {
+	catalog, err := o.catalog.Get(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Clear shared model storage
-	o.modelNames = []string{}
-
-	var models []Model
-	for _, apiModel := range modelsResponse.Models {
-		// Split model name
-		parts := strings.Split(apiModel.ID, "/")
-		name := parts[len(parts)-1]
-
-		// Store name in shared storage
-		o.modelNames = append(o.modelNames, apiModel.ID)
-
-		// Create model struct
-		model := Model{
-			Name:       name,
-			Model:      name,
-			ModifiedAt: currentTime,
-			Size:       0, // Stubbed size
-			Digest:     name,
-			Details: ModelDetails{
-				ParentModel:       "",
-				Format:            "gguf",
-				Family:            "claude",
-				Families:          []string{"claude"},
-				ParameterSize:     "175B",
-				QuantizationLevel: "Q4_K_M",
-			},
-		}
-		models = append(models, model)
+	id, err := matchModelID(catalog.ids, modelName)
+	if err != nil {
+		return nil, err
 	}
-
-	return models, nil
+	return catalog.byID[id].show(), nil
 }
 
-func (o *OpenrouterProvider) GetModelDetails(modelName string) (map[string]interface{}, error) {
-	// Stub response; replace with actual model details if available
-	currentTime := time.Now().Format(time.RFC3339)
-	return map[string]interface{}{
-		"license":    "STUB License",
-		"system":     "STUB SYSTEM",
-		"modifiedAt": currentTime,
-		"details": map[string]interface{}{
-			"format":             "gguf",
-			"parameter_size":     "200B",
-			"quantization_level": "Q4_K_M",
-		},
-		"model_info": map[string]interface{}{
-			"architecture":    "STUB",
-			"context_length":  200000,
-			"parameter_count": 200_000_000_000,
-		},
-	}, nil
+// RefreshModels fetches the model catalog again.
+func (o *OpenrouterProvider) RefreshModels(ctx context.Context) error {
+	if o.catalog == nil {
+		return nil
+	}
+	return o.catalog.Refresh(ctx)
 }
 
-func (o *OpenrouterProvider) GetFullModelName(alias string) (string, error) {
-	// If modelNames is empty or not populated yet, try to get models first
-	if len(o.modelNames) == 0 {
-		_, err := o.GetModels()
-		if err != nil {
-			return "", fmt.Errorf("failed to get models: %w", err)
-		}
+// CatalogModel returns the catalog entry of a model ID.
+func (o *OpenrouterProvider) CatalogModel(ctx context.Context, id string) *openrouterModel {
+	if o.catalog == nil {
+		return nil
+	}
+	catalog, err := o.catalog.Get(ctx)
+	if err != nil {
+		return nil
 	}
+	id, _ = splitRoutingVariant(id)
+	return catalog.byID[id]
+}
 
-	// First try exact match
-	for _, fullName := range o.modelNames {
-		if fullName == alias {
-			return fullName, nil
-		}
+// ModelIDs returns the IDs of the model catalog.
+func (o *OpenrouterProvider) ModelIDs(ctx context.Context) ([]string, error) {
+	if o.catalog == nil {
+		return nil, nil
 	}
+	catalog, err := o.catalog.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.ids, nil
+}
 
-	// Then try suffix match
-	for _, fullName := range o.modelNames {
-		if strings.HasSuffix(fullName, alias) {
-			return fullName, nil
-		}
+func (o *OpenrouterProvider) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	catalog, err := o.catalog.Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get models: %w", err)
 	}
 
-	// If no match found, just use the alias as is
-	// This allows direct use of model names that might not be in the list
-	return alias, nil
+	// Routing variants like ":nitro" are kept on the model they are added to
+	name, variant := splitRoutingVariant(alias)
+	fullName, err := matchModelID(catalog.ids, name)
+	if errors.Is(err, errModelNotFound) {
+		// Unknown names are used as they are. This allows direct use
+		// of model names that might not be in the list
+		return alias, nil
+	}
+	return fullName + variant, err
 }
 
 // Helper function for min (for Go versions that don't have it built-in)
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	anthropicDefaultBaseURL = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+	// The Messages API requires max_tokens, Ollama clients rarely send num_predict
+	anthropicDefaultMaxTokens = 4096
+)
+
+// AnthropicProvider talks to the native Anthropic Messages API.
+type AnthropicProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewAnthropicProvider(baseURL string, apiKey string) *AnthropicProvider {
+	if baseURL == "" {
+		baseURL = anthropicDefaultBaseURL
+	}
+	slog.Info("Using Anthropic backend", "baseURL", baseURL)
+
+	return &AnthropicProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+	}
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	Messages      []anthropicMessage `json:"messages"`
+	System        string             `json:"system,omitempty"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	TopK          *int               `json:"top_k,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicContent struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	ID         string             `json:"id"`
+	Model      string             `json:"model"`
+	Content    []anthropicContent `json:"content"`
+	StopReason string             `json:"stop_reason"`
+	Usage      anthropicUsage     `json:"usage"`
+}
+
+// newAnthropicRequest converts an OpenAI-style chat request into a Messages API request.
+func newAnthropicRequest(messages []openai.ChatCompletionMessage, modelName string, stream bool, opts RequestOptions) anthropicRequest {
+	req := anthropicRequest{
+		Model:     modelName,
+		MaxTokens: anthropicDefaultMaxTokens,
+		Stream:    stream,
+	}
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleSystem:
+			// System prompts are a top-level field in the Messages API
+			if req.System != "" {
+				req.System += "\n\n"
+			}
+			req.System += messageText(msg)
+		case openai.ChatMessageRoleTool:
+			// Tool results are sent back as user content
+			req.Messages = appendAnthropicContent(req.Messages, "user", anthropicContent{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.Content,
+			})
+		case openai.ChatMessageRoleAssistant:
+			var content []anthropicContent
+			if text := messageText(msg); text != "" {
+				content = append(content, anthropicContent{Type: "text", Text: text})
+			}
+			for _, call := range msg.ToolCalls {
+				content = append(content, anthropicContent{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: json.RawMessage(toolArgumentsToString(json.RawMessage(call.Function.Arguments))),
+				})
+			}
+			req.Messages = appendAnthropicContent(req.Messages, "assistant", content...)
+		default:
+			req.Messages = appendAnthropicContent(req.Messages, "user", anthropicUserContent(msg)...)
+		}
+	}
+
+	for _, tool := range opts.Tools {
+		if tool.Function == nil {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+		req.Tools = append(req.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	if opts.Format != nil {
+		slog.Warn("Anthropic backend has no structured output support, ignoring format", "model", modelName)
+	}
+
+	// Reuse the Ollama option mapping and pick the parameters the Messages API knows
+	var mapped openai.ChatCompletionRequest
+	extra := applyOllamaOptions(&mapped, opts.Options)
+	if mapped.Temperature != 0 || extra["temperature"] != nil {
+		req.Temperature = &mapped.Temperature
+	}
+	if mapped.TopP != 0 {
+		req.TopP = &mapped.TopP
+	}
+	if topK, ok := extra["top_k"].(int); ok {
+		req.TopK = &topK
+	}
+	if mapped.MaxTokens > 0 {
+		req.MaxTokens = mapped.MaxTokens
+	}
+	req.StopSequences = mapped.Stop
+	if mapped.Seed != nil || mapped.PresencePenalty != 0 || mapped.FrequencyPenalty != 0 || extra["min_p"] != nil || extra["repetition_penalty"] != nil {
+		slog.Warn("Anthropic backend does not support seed, min_p or penalty options, ignoring them", "model", modelName)
+	}
+
+	return req
+}
+
+// messageText returns the text of a message, joining the text parts of multimodal content.
+func messageText(msg openai.ChatCompletionMessage) string {
+	if len(msg.MultiContent) == 0 {
+		return msg.Content
+	}
+	var parts []string
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText && part.Text != "" {
+			parts = append(parts, part.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func anthropicUserContent(msg openai.ChatCompletionMessage) []anthropicContent {
+	if len(msg.MultiContent) == 0 {
+		if msg.Content == "" {
+			return nil
+		}
+		return []anthropicContent{{Type: "text", Text: msg.Content}}
+	}
+
+	var content []anthropicContent
+	for _, part := range msg.MultiContent {
+		switch {
+		case part.Type == openai.ChatMessagePartTypeText && part.Text != "":
+			content = append(content, anthropicContent{Type: "text", Text: part.Text})
+		case part.Type == openai.ChatMessagePartTypeImageURL && part.ImageURL != nil:
+			content = append(content, anthropicContent{Type: "image", Source: anthropicImage(part.ImageURL.URL)})
+		}
+	}
+	return content
+}
+
+// anthropicImage converts a data URL (as produced by formatImageForAPI) or a plain URL into an image source.
+func anthropicImage(url string) *anthropicImageSource {
+	if mediaType, data, ok := splitDataURL(url); ok {
+		return &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}
+	}
+	return &anthropicImageSource{Type: "url", URL: url}
+}
+
+// splitDataURL splits "data:<media type>;base64,<data>" into its media type and data.
+func splitDataURL(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(header, ";base64"), data, true
+}
+
+// appendAnthropicContent adds content to the conversation, merging consecutive
+// messages of the same role as the Messages API requires alternating roles.
+func appendAnthropicContent(messages []anthropicMessage, role string, content ...anthropicContent) []anthropicMessage {
+	if len(content) == 0 {
+		return messages
+	}
+	if len(messages) > 0 && messages[len(messages)-1].Role == role {
+		messages[len(messages)-1].Content = append(messages[len(messages)-1].Content, content...)
+		return messages
+	}
+	return append(messages, anthropicMessage{Role: role, Content: content})
+}
+
+// anthropicFinishReason maps Anthropic stop reasons to OpenAI finish reasons.
+func anthropicFinishReason(stopReason string) openai.FinishReason {
+	switch stopReason {
+	case "max_tokens":
+		return openai.FinishReasonLength
+	case "tool_use":
+		return openai.FinishReasonToolCalls
+	case "":
+		return ""
+	default:
+		return openai.FinishReasonStop
+	}
+}
+
+func (a *AnthropicProvider) newHTTPRequest(ctx context.Context, method string, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (a *AnthropicProvider) send(ctx context.Context, body anthropicRequest) (*http.Response, error) {
+	req, err := a.newHTTPRequest(ctx, http.MethodPost, "/v1/messages", body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, upstreamHTTPError(resp)
+	}
+	return resp, nil
+}
+
+func (a *AnthropicProvider) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error) {
+	resp, err := a.send(ctx, newAnthropicRequest(messages, modelName, false, opts))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var anthropicResp anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to decode Anthropic response: %w", err)
+	}
+
+	record := newUsageRecord(ctx)
+	record.GenerationID, record.Model = anthropicResp.ID, anthropicResp.Model
+	record.PromptTokens, record.CompletionTokens = anthropicResp.Usage.InputTokens, anthropicResp.Usage.OutputTokens
+	usageLog.Add(record)
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	for _, block := range anthropicResp.Content {
+		switch block.Type {
+		case "text":
+			message.Content += block.Text
+		case "tool_use":
+			message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+				ID:   block.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      block.Name,
+					Arguments: string(block.Input),
+				},
+			})
+		}
+	}
+
+	result := openai.ChatCompletionResponse{
+		ID:      anthropicResp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   anthropicResp.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      message,
+			FinishReason: anthropicFinishReason(anthropicResp.StopReason),
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     anthropicResp.Usage.InputTokens,
+			CompletionTokens: anthropicResp.Usage.OutputTokens,
+			TotalTokens:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
+		},
+	}
+	return ChatResponse{ChatCompletionResponse: result}, nil
+}
+
+func (a *AnthropicProvider) ChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	resp, err := a.send(ctx, newAnthropicRequest(messages, modelName, true, opts))
+	if err != nil {
+		return nil, err
+	}
+	return &anthropicStream{
+		body:        resp.Body,
+		reader:      bufio.NewReader(resp.Body),
+		model:       modelName,
+		toolIndexes: make(map[int]int),
+		usage:       newUsageRecord(ctx),
+	}, nil
+}
+
+func (a *AnthropicProvider) Generate(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatResponse, error) {
+	return a.Chat(ctx, buildGenerateMessages(prompt, systemPrompt, images), modelName, opts)
+}
+
+func (a *AnthropicProvider) GenerateStream(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatCompletionStream, error) {
+	return a.ChatStream(ctx, buildGenerateMessages(prompt, systemPrompt, images), modelName, opts)
+}
+
+func (a *AnthropicProvider) GetModels(ctx context.Context) ([]Model, error) {
+	req, err := a.newHTTPRequest(ctx, http.MethodGet, "/v1/models?limit=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamHTTPError(resp)
+	}
+
+	var modelsResponse struct {
+		Data []struct {
+			ID        string    `json:"id"`
+			CreatedAt time.Time `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&modelsResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode Anthropic models: %w", err)
+	}
+
+	models := make([]Model, 0, len(modelsResponse.Data))
+	for _, apiModel := range modelsResponse.Data {
+		models = append(models, Model{
+			Name:       apiModel.ID,
+			Model:      apiModel.ID,
+			ModifiedAt: apiModel.CreatedAt.Format(time.RFC3339),
+			Digest:     apiModel.ID,
+			Details: ModelDetails{
+				Format:   "api",
+				Family:   "claude",
+				Families: []string{"claude"},
+			},
+		})
+	}
+	return models, nil
+}
+
+func (a *AnthropicProvider) GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"modified_at": time.Now().Format(time.RFC3339),
+		"details": map[string]interface{}{
+			"format": "api",
+			"family": "claude",
+		},
+		"model_info": map[string]interface{}{
+			"architecture":   "claude",
+			"context_length": 200000,
+		},
+		"capabilities": []string{"completion", "tools", "vision"},
+	}, nil
+}
+
+func (a *AnthropicProvider) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	// Anthropic model IDs are used as they are
+	return alias, nil
+}
+
+// anthropicStream converts Messages API server-sent events into OpenAI stream chunks.
+type anthropicStream struct {
+	body        io.ReadCloser
+	reader      *bufio.Reader
+	id          string
+	model       string
+	inputTokens int
+	toolIndexes map[int]int // content block index -> tool call index
+	done        bool
+
+	// Usage record, added with the final usage or, for aborted streams, on Close
+	usage         usageRecord
+	usageRecorded bool
+}
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		ID    string         `json:"id"`
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock anthropicContent `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *anthropicStream) Recv() (ChatChunk, error) {
+	for !s.done {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return ChatChunk{}, io.ErrUnexpectedEOF
+			}
+			return ChatChunk{}, err
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			// Event names are repeated in the data payload
+			continue
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return ChatChunk{}, fmt.Errorf("failed to decode Anthropic stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			s.id = event.Message.ID
+			if event.Message.Model != "" {
+				s.model = event.Message.Model
+			}
+			s.inputTokens = event.Message.Usage.InputTokens
+			s.usage.GenerationID, s.usage.Model, s.usage.PromptTokens = s.id, s.model, s.inputTokens
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				index := len(s.toolIndexes)
+				s.toolIndexes[event.Index] = index
+				return s.chunk(openai.ChatCompletionStreamChoiceDelta{
+					ToolCalls: []openai.ToolCall{{
+						Index:    &index,
+						ID:       event.ContentBlock.ID,
+						Type:     openai.ToolTypeFunction,
+						Function: openai.FunctionCall{Name: event.ContentBlock.Name},
+					}},
+				}, "", nil), nil
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				return s.chunk(openai.ChatCompletionStreamChoiceDelta{Content: event.Delta.Text}, "", nil), nil
+			case "input_json_delta":
+				index := s.toolIndexes[event.Index]
+				return s.chunk(openai.ChatCompletionStreamChoiceDelta{
+					ToolCalls: []openai.ToolCall{{
+						Index:    &index,
+						Function: openai.FunctionCall{Arguments: event.Delta.PartialJSON},
+					}},
+				}, "", nil), nil
+			}
+		case "message_delta":
+			usage := &openai.Usage{
+				PromptTokens:     s.inputTokens,
+				CompletionTokens: event.Usage.OutputTokens,
+				TotalTokens:      s.inputTokens + event.Usage.OutputTokens,
+			}
+			s.usage.CompletionTokens = event.Usage.OutputTokens
+			s.recordUsage()
+			return s.chunk(openai.ChatCompletionStreamChoiceDelta{}, anthropicFinishReason(event.Delta.StopReason), usage), nil
+		case "message_stop":
+			s.done = true
+		case "error":
+			return ChatChunk{}, fmt.Errorf("anthropic stream error (%s): %s", event.Error.Type, event.Error.Message)
+		}
+	}
+	return ChatChunk{}, io.EOF
+}
+
+func (s *anthropicStream) chunk(delta openai.ChatCompletionStreamChoiceDelta, finishReason openai.FinishReason, usage *openai.Usage) ChatChunk {
+	return ChatChunk{ChatCompletionStreamResponse: openai.ChatCompletionStreamResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   s.model,
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Delta:        delta,
+			FinishReason: finishReason,
+		}},
+		Usage: usage,
+	}}
+}
+
+// recordUsage adds the usage record once, if the message has started.
+func (s *anthropicStream) recordUsage() {
+	if s.usageRecorded || s.usage.GenerationID == "" {
+		return
+	}
+	s.usageRecorded = true
+	usageLog.Add(s.usage)
+}
+
+func (s *anthropicStream) Close() error {
+	s.recordUsage()
+	return s.body.Close()
+}
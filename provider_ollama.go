@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OllamaProvider passes requests through to a real Ollama server using its native API,
+// so Ollama-only options such as num_ctx keep working.
+type OllamaProvider struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu         sync.RWMutex // Guards modelNames, which GetModels replaces
+	modelNames []string
+}
+
+func NewOllamaProvider(baseURL string) *OllamaProvider {
+	slog.Info("Using Ollama backend", "baseURL", baseURL)
+	return &OllamaProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{},
+	}
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Tools    []openai.Tool          `json:"tools,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Think    interface{}            `json:"think,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// newOllamaChatRequest converts OpenAI-style messages back into an Ollama chat request.
+func newOllamaChatRequest(messages []openai.ChatCompletionMessage, modelName string, stream bool, opts RequestOptions) ollamaChatRequest {
+	req := ollamaChatRequest{
+		Model:   modelName,
+		Stream:  stream,
+		Tools:   opts.Tools,
+		Options: opts.Options,
+	}
+	if _, ok := opts.Options["openrouter"]; ok {
+		// OpenRouter's provider preferences mean nothing to Ollama
+		req.Options = make(map[string]interface{}, len(opts.Options))
+		for key, value := range opts.Options {
+			if key != "openrouter" {
+				req.Options[key] = value
+			}
+		}
+	}
+
+	if opts.Reasoning != nil {
+		req.Think = ollamaThink(opts.Reasoning)
+	}
+
+	if opts.Format != nil {
+		if opts.Format.JSONSchema != nil {
+			if schema, err := opts.Format.JSONSchema.Schema.MarshalJSON(); err == nil {
+				req.Format = schema
+			}
+		} else {
+			req.Format = json.RawMessage(`"json"`)
+		}
+	}
+
+	toolNames := make(map[string]string)
+	for _, msg := range messages {
+		ollamaMsg := ollamaMessage{
+			Role:    msg.Role,
+			Content: messageText(msg),
+		}
+		for _, part := range msg.MultiContent {
+			if part.Type != openai.ChatMessagePartTypeImageURL || part.ImageURL == nil {
+				continue
+			}
+			// Ollama expects plain base64 image data
+			if _, data, ok := splitDataURL(part.ImageURL.URL); ok {
+				ollamaMsg.Images = append(ollamaMsg.Images, data)
+			} else {
+				slog.Warn("Ollama backend only supports inline images, skipping image URL")
+			}
+		}
+		if len(msg.ToolCalls) > 0 {
+			ollamaMsg.ToolCalls = toOllamaToolCalls(msg.ToolCalls)
+			for _, call := range msg.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+			}
+		}
+		if msg.Role == openai.ChatMessageRoleTool {
+			ollamaMsg.ToolName = toolNames[msg.ToolCallID]
+		}
+		req.Messages = append(req.Messages, ollamaMsg)
+	}
+
+	return req
+}
+
+// ollamaThink converts the reasoning settings back into Ollama's think parameter.
+// Ollama has no token budget, so a budget just enables thinking.
+func ollamaThink(reasoning map[string]interface{}) interface{} {
+	if enabled, ok := reasoning["enabled"].(bool); ok && !enabled {
+		return false
+	}
+	if effort, ok := reasoning["effort"].(string); ok {
+		return effort
+	}
+	return true
+}
+
+// ollamaFinishReason maps Ollama's done_reason to an OpenAI finish reason.
+func ollamaFinishReason(doneReason string, hasToolCalls bool) openai.FinishReason {
+	switch {
+	case hasToolCalls:
+		return openai.FinishReasonToolCalls
+	case doneReason == "length":
+		return openai.FinishReasonLength
+	default:
+		return openai.FinishReasonStop
+	}
+}
+
+// ollamaToolCallsToOpenAI assigns IDs and indexes to Ollama tool calls.
+func ollamaToolCallsToOpenAI(calls []OllamaToolCall, offset int) []openai.ToolCall {
+	toolCalls := make([]openai.ToolCall, 0, len(calls))
+	for i, call := range calls {
+		index := offset + i
+		toolCalls = append(toolCalls, openai.ToolCall{
+			Index: &index,
+			ID:    fmt.Sprintf("call_%d", index),
+			Type:  openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: toolArgumentsToString(call.Function.Arguments),
+			},
+		})
+	}
+	return toolCalls
+}
+
+func (o *OllamaProvider) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, upstreamHTTPError(resp)
+	}
+	return resp, nil
+}
+
+func (o *OllamaProvider) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error) {
+	resp, err := o.post(ctx, "/api/chat", newOllamaChatRequest(messages, modelName, false, opts))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var ollamaResp ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to decode Ollama response: %w", err)
+	}
+
+	record := newUsageRecord(ctx)
+	record.Model, record.PromptTokens, record.CompletionTokens = ollamaResp.Model, ollamaResp.PromptEvalCount, ollamaResp.EvalCount
+	usageLog.Add(record)
+
+	message := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: ollamaResp.Message.Content,
+	}
+	if len(ollamaResp.Message.ToolCalls) > 0 {
+		message.ToolCalls = ollamaToolCallsToOpenAI(ollamaResp.Message.ToolCalls, 0)
+	}
+
+	result := openai.ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   ollamaResp.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      message,
+			FinishReason: ollamaFinishReason(ollamaResp.DoneReason, len(message.ToolCalls) > 0),
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     ollamaResp.PromptEvalCount,
+			CompletionTokens: ollamaResp.EvalCount,
+			TotalTokens:      ollamaResp.PromptEvalCount + ollamaResp.EvalCount,
+		},
+	}
+	return ChatResponse{ChatCompletionResponse: result, Reasoning: ollamaResp.Message.Thinking}, nil
+}
+
+func (o *OllamaProvider) ChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	resp, err := o.post(ctx, "/api/chat", newOllamaChatRequest(messages, modelName, true, opts))
+	if err != nil {
+		return nil, err
+	}
+	return &ollamaStream{
+		body:    resp.Body,
+		scanner: newNDJSONScanner(resp.Body),
+		usage:   newUsageRecord(ctx),
+	}, nil
+}
+
+func (o *OllamaProvider) Generate(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatResponse, error) {
+	return o.Chat(ctx, buildGenerateMessages(prompt, systemPrompt, images), modelName, opts)
+}
+
+func (o *OllamaProvider) GenerateStream(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatCompletionStream, error) {
+	return o.ChatStream(ctx, buildGenerateMessages(prompt, systemPrompt, images), modelName, opts)
+}
+
+func (o *OllamaProvider) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error) {
+	body := map[string]interface{}{
+		"model":    string(req.Model),
+		"input":    req.Input,
+		"truncate": truncate,
+	}
+	if req.Dimensions > 0 {
+		body["dimensions"] = req.Dimensions
+	}
+	resp, err := o.post(ctx, "/api/embed", body)
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var embedResp struct {
+		Model           string      `json:"model"`
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return openai.EmbeddingResponse{}, fmt.Errorf("failed to decode Ollama embeddings: %w", err)
+	}
+
+	result := openai.EmbeddingResponse{
+		Object: "list",
+		Model:  openai.EmbeddingModel(embedResp.Model),
+		Usage: openai.Usage{
+			PromptTokens: embedResp.PromptEvalCount,
+			TotalTokens:  embedResp.PromptEvalCount,
+		},
+	}
+	for i, embedding := range embedResp.Embeddings {
+		result.Data = append(result.Data, openai.Embedding{Object: "embedding", Embedding: embedding, Index: i})
+	}
+	return result, nil
+}
+
+func (o *OllamaProvider) GetModels(ctx context.Context) ([]Model, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamHTTPError(resp)
+	}
+
+	var tags struct {
+		Models []Model `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama models: %w", err)
+	}
+
+	modelNames := make([]string, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		modelNames = append(modelNames, model.Name)
+	}
+	o.mu.Lock()
+	o.modelNames = modelNames
+	o.mu.Unlock()
+
+	return tags.Models, nil
+}
+
+func (o *OllamaProvider) GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error) {
+	resp, err := o.post(ctx, "/api/show", map[string]string{"model": modelName})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var details map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama model details: %w", err)
+	}
+	return details, nil
+}
+
+// ModelIDs returns the names of the local models, fetching them on first use.
+func (o *OllamaProvider) ModelIDs(ctx context.Context) ([]string, error) {
+	o.mu.RLock()
+	modelNames := o.modelNames
+	o.mu.RUnlock()
+	if len(modelNames) == 0 {
+		if _, err := o.GetModels(ctx); err != nil {
+			return nil, err
+		}
+		o.mu.RLock()
+		modelNames = o.modelNames
+		o.mu.RUnlock()
+	}
+	return modelNames, nil
+}
+
+func (o *OllamaProvider) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	modelNames, err := o.ModelIDs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get models: %w", err)
+	}
+
+	// Ollama resolves a missing tag to ":latest" itself, so only check the name exists
+	for _, name := range modelNames {
+		if name == alias || name == alias+":latest" {
+			return alias, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errModelNotFound, alias)
+}
+
+// newNDJSONScanner returns a line scanner with room for large NDJSON objects.
+func newNDJSONScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return scanner
+}
+
+// ollamaStream converts Ollama's NDJSON chat stream into OpenAI stream chunks.
+type ollamaStream struct {
+	body      io.ReadCloser
+	scanner   *bufio.Scanner
+	toolCalls int
+	done      bool
+	usage     usageRecord // Added with the final chunk, which has the token counts
+}
+
+func (s *ollamaStream) Recv() (ChatChunk, error) {
+	for !s.done && s.scanner.Scan() {
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return ChatChunk{}, fmt.Errorf("failed to decode Ollama stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return ChatChunk{}, errors.New(chunk.Error)
+		}
+
+		delta := openai.ChatCompletionStreamChoiceDelta{Content: chunk.Message.Content}
+		if len(chunk.Message.ToolCalls) > 0 {
+			delta.ToolCalls = ollamaToolCallsToOpenAI(chunk.Message.ToolCalls, s.toolCalls)
+			s.toolCalls += len(chunk.Message.ToolCalls)
+		}
+		response := ChatChunk{Reasoning: chunk.Message.Thinking}
+		response.Object = "chat.completion.chunk"
+		response.Created = time.Now().Unix()
+		response.Model = chunk.Model
+		response.Choices = []openai.ChatCompletionStreamChoice{{Delta: delta}}
+		if chunk.Done {
+			s.done = true
+			response.Choices[0].FinishReason = ollamaFinishReason(chunk.DoneReason, s.toolCalls > 0)
+			response.Usage = &openai.Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+			s.usage.Model, s.usage.PromptTokens, s.usage.CompletionTokens = chunk.Model, chunk.PromptEvalCount, chunk.EvalCount
+			usageLog.Add(s.usage)
+		}
+		return response, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return ChatChunk{}, err
+	}
+	if !s.done {
+		return ChatChunk{}, io.ErrUnexpectedEOF
+	}
+	return ChatChunk{}, io.EOF
+}
+
+func (s *ollamaStream) Close() error {
+	return s.body.Close()
+}
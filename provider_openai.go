@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OpenAIProvider talks to any OpenAI-compatible API (OpenAI, vLLM, LM Studio, LiteLLM, ...).
+// Chat requests are built like the OpenRouter ones, without OpenRouter's own fields;
+// model listing differs.
+type OpenAIProvider struct {
+	*OpenrouterProvider
+}
+
+func NewOpenAIProvider(baseURL string, apiKey string) *OpenAIProvider {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	config.HTTPClient = &http.Client{
+		Transport: &extraBodyTransport{
+			base: &usageTransport{base: &responseCaptureTransport{base: http.DefaultTransport}},
+		},
+	}
+	slog.Info("Using OpenAI-compatible backend", "baseURL", baseURL)
+
+	return &OpenAIProvider{
+		OpenrouterProvider: &OpenrouterProvider{
+			client: openai.NewClientWithConfig(config),
+			apiKey: apiKey,
+		},
+	}
+}
+
+func (p *OpenAIProvider) GetModels(ctx context.Context) ([]Model, error) {
+	modelsResponse, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]Model, 0, len(modelsResponse.Models))
+	for _, apiModel := range modelsResponse.Models {
+		modifiedAt := time.Now()
+		if apiModel.CreatedAt > 0 {
+			modifiedAt = time.Unix(apiModel.CreatedAt, 0)
+		}
+		models = append(models, Model{
+			Name:       apiModel.ID,
+			Model:      apiModel.ID,
+			ModifiedAt: modifiedAt.Format(time.RFC3339),
+			Digest:     apiModel.ID,
+			Details: ModelDetails{
+				Format:   "api",
+				Family:   apiModel.OwnedBy,
+				Families: []string{apiModel.OwnedBy},
+			},
+		})
+	}
+	return models, nil
+}
+
+func (p *OpenAIProvider) GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error) {
+	// OpenAI-compatible APIs expose no model metadata beyond the ID
+	return map[string]interface{}{
+		"modified_at": time.Now().Format(time.RFC3339),
+		"details": map[string]interface{}{
+			"format": "api",
+		},
+		"model_info": map[string]interface{}{},
+	}, nil
+}
+
+func (p *OpenAIProvider) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	// Model IDs of OpenAI-compatible APIs are used as they are
+	return alias, nil
+}
+
+func (p *OpenAIProvider) SupportsNativeFallback(models []string) bool {
+	// Only OpenRouter understands the "models" parameter
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestOpenAIProviderRequestBody(t *testing.T) {
+	bodies := make(chan map[string]interface{}, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/completions" {
+			fmt.Fprint(w, `{"id":"cmpl-1","model":"gpt-3.5-turbo-instruct","choices":[{"text":"Hi","finish_reason":"stop"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chat-1","model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	provider := NewOpenAIProvider(upstream.URL, "sk-test")
+	opts := RequestOptions{
+		Options: map[string]interface{}{
+			"temperature":    0,
+			"top_k":          40,
+			"min_p":          0.05,
+			"repeat_penalty": 1.1,
+			"seed":           7,
+			"openrouter":     map[string]interface{}{"provider": map[string]interface{}{"sort": "price"}},
+		},
+		Fallbacks: []string{"gpt-4o-mini"},
+		Provider:  map[string]interface{}{"order": []interface{}{"Azure"}},
+		Reasoning: map[string]interface{}{"effort": "high"},
+	}
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}}
+
+	if _, err := provider.Chat(context.Background(), messages, "gpt-4o", opts); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if _, err := provider.Complete(context.Background(), "Hello", "", "gpt-3.5-turbo-instruct", opts); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	for _, name := range []string{"chat", "completion"} {
+		body := <-bodies
+		for _, field := range openrouterFields {
+			if value, ok := body[field]; ok {
+				t.Errorf("%s request has OpenRouter's %s = %v", name, field, value)
+			}
+		}
+		if body["temperature"] != float64(0) || body["seed"] != float64(7) {
+			t.Errorf("%s request has temperature %v and seed %v, want 0 and 7", name, body["temperature"], body["seed"])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pullAllowsModels makes /api/pull add models the models filter excludes to the
+// filter file, instead of rejecting them.
+var pullAllowsModels bool
+
+// loadPullSettings reads PULL_ALLOWS_MODELS.
+func loadPullSettings() error {
+	value := os.Getenv("PULL_ALLOWS_MODELS")
+	if value == "" {
+		return nil
+	}
+	allows, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid PULL_ALLOWS_MODELS %q", value)
+	}
+	pullAllowsModels = allows
+	return nil
+}
+
+// modelExists reports whether the provider knows the model ID. Providers that
+// cannot list their models accept every name.
+func modelExists(ctx context.Context, provider Provider, id string) (bool, error) {
+	lister, ok := provider.(modelIDLister)
+	if !ok {
+		return true, nil
+	}
+	ids, err := lister.ModelIDs(ctx)
+	if err != nil {
+		return false, err
+	}
+	if ids == nil {
+		// No catalog to check against
+		return true, nil
+	}
+	return containsString(ids, id), nil
+}
+
+// pullModel checks that a model can be used, as there is nothing to download for
+// remote models. It returns the ID the name stands for.
+func pullModel(ctx context.Context, provider Provider, embeddings *EmbeddingService, name string) (string, error) {
+	for _, model := range embeddings.Models() {
+		if model.Name == name {
+			return embeddings.ResolveModel(ctx, name)
+		}
+	}
+
+	fullName, alias, err := resolveModelAlias(ctx, provider, name)
+	if err != nil {
+		return "", err
+	}
+	id, _ := splitRoutingVariant(fullName)
+	if err := requestKey(ctx).checkModel(filterTarget{name: name, id: id, meta: catalogModel(ctx, provider, id)}); err != nil {
+		return "", err
+	}
+	if alias != nil {
+		return fullName, nil
+	}
+	exists, err := modelExists(ctx, provider, id)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%w: %s", errModelNotFound, name)
+	}
+
+	target := filterTarget{name: name, id: id, meta: catalogModel(ctx, provider, id)}
+	if modelFilter.Load().Allows(target) {
+		return fullName, nil
+	}
+	// Extending the models filter affects all clients
+	if !pullAllowsModels || !requestKey(ctx).isAdmin() {
+		return "", fmt.Errorf("%w: %s", errModelNotAllowed, name)
+	}
+	return fullName, allowModel(target)
+}
+
+// registerPullRoutes adds /api/pull. Pulling a model validates it against the
+// catalog and reports Ollama's progress statuses without downloading anything.
+func registerPullRoutes(r *gin.Engine, provider Provider, embeddings *EmbeddingService) {
+	r.POST("/api/pull", func(c *gin.Context) {
+		var request struct {
+			Model    string `json:"model"`
+			Name     string `json:"name"` // Older clients
+			Insecure bool   `json:"insecure"`
+			Stream   *bool  `json:"stream"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		name := request.Model
+		if name == "" {
+			name = request.Name
+		}
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+			return
+		}
+
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		fullName, err := pullModel(ctx, provider, embeddings, name)
+		if err != nil {
+			var ambiguous *ambiguousModelError
+			if errors.Is(err, errModelNotFound) || errors.Is(err, errModelNotAllowed) || errors.Is(err, errModelNotAllowedForKey) || errors.As(err, &ambiguous) {
+				c.JSON(modelErrorStatus(err), gin.H{"error": "pull model manifest: " + err.Error()})
+				return
+			}
+			handleUpstreamError(c, "Error pulling model", err)
+			return
+		}
+		slog.Info("Pulled model", "model", name, "fullModelName", fullName)
+
+		if request.Stream != nil && !*request.Stream {
+			c.JSON(http.StatusOK, gin.H{"status": "success"})
+			return
+		}
+		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		for _, status := range []string{"pulling manifest", "verifying sha256 digest", "writing manifest", "success"} {
+			line, _ := json.Marshal(gin.H{"status": status})
+			c.Writer.Write(append(line, '\n'))
+			c.Writer.Flush()
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// fimFormats are the fill-in-the-middle prompt formats of code models, by a pattern
+// of the model ID. %[1]s is the text before the cursor, %[2]s the text after it.
+// Other models get the suffix in the request's suffix field.
+var fimFormats = []struct {
+	pattern *regexp.Regexp
+	format  string
+}{
+	{regexp.MustCompile(`codestral|devstral`), "[SUFFIX]%[2]s[PREFIX]%[1]s"},
+	{regexp.MustCompile(`deepseek-coder`), "<｜fim▁begin｜>%[1]s<｜fim▁hole｜>%[2]s<｜fim▁end｜>"},
+	{regexp.MustCompile(`qwen.*coder|codegemma`), "<|fim_prefix|>%[1]s<|fim_suffix|>%[2]s<|fim_middle|>"},
+	{regexp.MustCompile(`starcoder`), "<fim_prefix>%[1]s<fim_suffix>%[2]s<fim_middle>"},
+	{regexp.MustCompile(`codellama|code-llama`), "<PRE> %[1]s <SUF>%[2]s <MID>"},
+}
+
+// fimPrompt returns the prompt and suffix to send for a fill-in-the-middle request.
+// For models with known FIM tokens, the suffix is written into the prompt.
+func fimPrompt(modelID string, prompt string, suffix string) (string, string) {
+	if suffix == "" {
+		return prompt, ""
+	}
+	id := strings.ToLower(modelID)
+	for _, fim := range fimFormats {
+		if fim.pattern.MatchString(id) {
+			return fmt.Sprintf(fim.format, prompt, suffix), ""
+		}
+	}
+	return prompt, suffix
+}
+
+// responsePattern finds where the model's response goes in a prompt template.
+var responsePattern = regexp.MustCompile(`\{\{-?\s*\.Response\s*-?\}\}`)
+
+// templateFuncs are the template functions of Ollama that make sense for a prompt.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	},
+	"currentDate": func() string {
+		return time.Now().Format("2006-01-02")
+	},
+}
+
+// renderTemplate renders an Ollama prompt template (Go's text/template) with the
+// system prompt, prompt and suffix. Like Ollama, only the part before .Response is
+// used, as the rest follows the model's answer.
+func renderTemplate(text string, system string, prompt string, suffix string) (string, error) {
+	tmpl, err := template.New("prompt").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	if loc := responsePattern.FindStringIndex(text); loc != nil {
+		// The part before the response may cut through a block; then keep all of it
+		if head, err := template.New("prompt").Funcs(templateFuncs).Parse(text[:loc[0]]); err == nil {
+			tmpl = head
+		}
+	}
+
+	messages := []map[string]interface{}{}
+	if system != "" {
+		messages = append(messages, map[string]interface{}{"Role": "system", "Content": system})
+	}
+	messages = append(messages, map[string]interface{}{"Role": "user", "Content": prompt})
+	values := map[string]interface{}{
+		"System":   system,
+		"Prompt":   prompt,
+		"Suffix":   suffix,
+		"Response": "",
+		"Messages": messages,
+		"Tools":    []interface{}{},
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// rawPrompt returns the prompt and suffix for the text completions endpoint: the
+// rendered template, if there is one, in fill-in-the-middle format if there is a
+// suffix. A template that uses .Suffix takes care of the suffix itself.
+func rawPrompt(modelID string, tmpl string, system string, prompt string, suffix string) (string, string, error) {
+	if tmpl != "" {
+		rendered, err := renderTemplate(tmpl, system, prompt, suffix)
+		if err != nil {
+			return "", "", err
+		}
+		prompt = rendered
+		if strings.Contains(tmpl, ".Suffix") {
+			suffix = ""
+		}
+	}
+	prompt, suffix = fimPrompt(modelID, prompt, suffix)
+	return prompt, suffix, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import "testing"
+
+func TestFimPrompt(t *testing.T) {
+	tests := []struct {
+		model      string
+		wantPrompt string
+		wantSuffix string
+	}{
+		{"mistralai/codestral-2501", "[SUFFIX]}[PREFIX]func f() {", ""},
+		{"deepseek/deepseek-coder", "<｜fim▁begin｜>func f() {<｜fim▁hole｜>}<｜fim▁end｜>", ""},
+		{"qwen/qwen-2.5-coder-32b-instruct", "<|fim_prefix|>func f() {<|fim_suffix|>}<|fim_middle|>", ""},
+		{"google/CodeGemma-7b", "<|fim_prefix|>func f() {<|fim_suffix|>}<|fim_middle|>", ""},
+		{"bigcode/starcoder2-15b", "<fim_prefix>func f() {<fim_suffix>}<fim_middle>", ""},
+		{"meta-llama/codellama-34b-instruct", "<PRE> func f() { <SUF>} <MID>", ""},
+		{"openai/gpt-3.5-turbo-instruct", "func f() {", "}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			prompt, suffix := fimPrompt(tt.model, "func f() {", "}")
+			if prompt != tt.wantPrompt || suffix != tt.wantSuffix {
+				t.Errorf("fimPrompt() = %q, %q, want %q, %q", prompt, suffix, tt.wantPrompt, tt.wantSuffix)
+			}
+		})
+	}
+
+	if prompt, suffix := fimPrompt("mistralai/codestral-2501", "Hello", ""); prompt != "Hello" || suffix != "" {
+		t.Errorf("fimPrompt() without suffix = %q, %q", prompt, suffix)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		system   string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "up to the response",
+			template: "{{ if .System }}<|system|>{{ .System }}\n{{ end }}<|user|>{{ .Prompt }}\n<|assistant|>{{ .Response }}<|end|>",
+			system:   "Be brief.",
+			want:     "<|system|>Be brief.\n<|user|>Hi\n<|assistant|>",
+		},
+		{
+			name:     "without system",
+			template: "{{ if .System }}<|system|>{{ .System }}\n{{ end }}<|user|>{{ .Prompt }}\n<|assistant|>{{ .Response }}<|end|>",
+			want:     "<|user|>Hi\n<|assistant|>",
+		},
+		{
+			name:     "messages",
+			template: "{{ range .Messages }}[{{ .Role }}] {{ .Content }}\n{{ end }}",
+			system:   "Be brief.",
+			want:     "[system] Be brief.\n[user] Hi\n",
+		},
+		{
+			name:     "response inside a block",
+			template: "{{ if .Prompt }}Q: {{ .Prompt }} A: {{ .Response }}{{ end }}",
+			want:     "Q: Hi A: ",
+		},
+		{
+			name:     "suffix",
+			template: "<PRE>{{ .Prompt }}<SUF>{{ .Suffix }}<MID>",
+			want:     "<PRE>Hi<SUF>!<MID>",
+		},
+		{
+			name:     "json",
+			template: "{{ json .Prompt }}",
+			want:     `"Hi"`,
+		},
+		{
+			name:     "invalid",
+			template: "{{ .Prompt ",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.template, tt.system, "Hi", "!")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("renderTemplate() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRawPrompt(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		template   string
+		wantPrompt string
+		wantSuffix string
+	}{
+		{"raw", "openai/gpt-3.5-turbo-instruct", "", "func f() {", "}"},
+		{"raw with FIM tokens", "bigcode/starcoder2-15b", "", "<fim_prefix>func f() {<fim_suffix>}<fim_middle>", ""},
+		{"template without suffix", "openai/gpt-3.5-turbo-instruct", "// {{ .Prompt }}", "// func f() {", "}"},
+		{"template with suffix", "bigcode/starcoder2-15b", "<PRE>{{ .Prompt }}<SUF>{{ .Suffix }}<MID>", "<PRE>func f() {<SUF>}<MID>", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, suffix, err := rawPrompt(tt.model, tt.template, "", "func f() {", "}")
+			if err != nil {
+				t.Fatalf("rawPrompt() error = %v", err)
+			}
+			if prompt != tt.wantPrompt || suffix != tt.wantSuffix {
+				t.Errorf("rawPrompt() = %q, %q, want %q, %q", prompt, suffix, tt.wantPrompt, tt.wantSuffix)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Reasoning travels from the providers to the handlers in the Reasoning field of
+// ChatResponse and ChatChunk. Models without a separate reasoning field write it into
+// their answer in <think> tags, which the handlers split off the same way.
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// inlineThinking leaves the reasoning in the content, in <think> tags, for clients
+// that do not know the "thinking" field.
+var inlineThinking bool
+
+// loadThinkingSettings reads INLINE_THINKING.
+func loadThinkingSettings() error {
+	value := os.Getenv("INLINE_THINKING")
+	if value == "" {
+		return nil
+	}
+	inline, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid INLINE_THINKING %q", value)
+	}
+	inlineThinking = inline
+	return nil
+}
+
+// thinkOption is Ollama's "think" request parameter: true or false, or a reasoning
+// effort ("low", "medium", "high"). A number sets the reasoning token budget.
+type thinkOption struct {
+	enabled   bool
+	effort    string
+	maxTokens int
+}
+
+func (t *thinkOption) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*t = thinkOption{enabled: v}
+	case string:
+		switch v {
+		case "low", "medium", "high":
+			*t = thinkOption{enabled: true, effort: v}
+		default:
+			return fmt.Errorf("invalid think value %q, expected true, false, \"low\", \"medium\" or \"high\"", v)
+		}
+	case float64:
+		if v <= 0 {
+			return fmt.Errorf("invalid think budget %v", v)
+		}
+		*t = thinkOption{enabled: true, maxTokens: int(v)}
+	default:
+		return fmt.Errorf("think must be a boolean, an effort or a token budget, got %T", value)
+	}
+	return nil
+}
+
+// reasoning returns OpenRouter's "reasoning" object for the option, nil if the client
+// did not ask for anything.
+func (t *thinkOption) reasoning() map[string]interface{} {
+	switch {
+	case t == nil:
+		return nil
+	case !t.enabled:
+		return map[string]interface{}{"enabled": false}
+	case t.effort != "":
+		return map[string]interface{}{"effort": t.effort}
+	case t.maxTokens > 0:
+		return map[string]interface{}{"max_tokens": t.maxTokens}
+	default:
+		return map[string]interface{}{"enabled": true}
+	}
+}
+
+// disabled reports whether the client asked for no thinking.
+func (t *thinkOption) disabled() bool {
+	return t != nil && !t.enabled
+}
+
+// Where a thinkingParser is in the response
+const (
+	parseStart    = iota // Before the response, which may open with <think>
+	parseThinking        // Inside <think>
+	parseAfter           // After </think>, skipping whitespace
+	parseContent         // The rest of the response
+)
+
+// thinkingParser splits a response into the thinking and the content. The thinking
+// comes from the upstream's reasoning field or, for models that have none, from the
+// <think> tags at the start of the content. Tags split across stream chunks are held
+// back until they are complete. Once the upstream sends a reasoning field, the content
+// is taken as it is.
+type thinkingParser struct {
+	inline bool // Put the thinking in the content, in <think> tags
+	drop   bool // Leave the thinking out
+
+	separate bool // The upstream sends the reasoning in its own field
+	state    int
+	buf      string
+	tags     thinkingTags // Of the inline thinking
+}
+
+// newThinkingParser returns the parser for a request with the think option.
+func newThinkingParser(think *thinkOption) *thinkingParser {
+	return &thinkingParser{inline: inlineThinking, drop: think.disabled()}
+}
+
+// newOpenAIThinkingParser returns the parser for the OpenAI-compatible API, which has
+// no field for the reasoning: it is left out, unless INLINE_THINKING is set.
+func newOpenAIThinkingParser() *thinkingParser {
+	return &thinkingParser{inline: inlineThinking, drop: !inlineThinking}
+}
+
+// Add returns the thinking and content of the next piece of the response, from its
+// reasoning and content.
+func (p *thinkingParser) Add(reasoning string, text string) (thinking string, content string) {
+	if reasoning != "" {
+		p.separate = true
+	}
+	if p.separate {
+		// What was held back is content, as the reasoning is not in the content
+		content = p.buf + text
+		p.buf = ""
+		return p.result(reasoning, content, false)
+	}
+
+	p.buf += text
+	for {
+		switch p.state {
+		case parseStart:
+			trimmed := strings.TrimLeft(p.buf, " \t\r\n")
+			if strings.HasPrefix(trimmed, thinkOpen) {
+				p.buf = trimmed[len(thinkOpen):]
+				p.state = parseThinking
+				continue
+			}
+			if strings.HasPrefix(thinkOpen, trimmed) {
+				// Not enough to tell yet
+				return p.result(thinking, content, false)
+			}
+			p.state = parseContent
+		case parseThinking:
+			if i := strings.Index(p.buf, thinkClose); i >= 0 {
+				thinking += p.buf[:i]
+				p.buf = p.buf[i+len(thinkClose):]
+				p.state = parseAfter
+				continue
+			}
+			// Hold back what may be the start of </think>
+			keep := 0
+			for n := len(thinkClose) - 1; n > 0; n-- {
+				if strings.HasSuffix(p.buf, thinkClose[:n]) {
+					keep = n
+					break
+				}
+			}
+			thinking += p.buf[:len(p.buf)-keep]
+			p.buf = p.buf[len(p.buf)-keep:]
+			return p.result(thinking, content, false)
+		case parseAfter:
+			p.buf = strings.TrimLeft(p.buf, " \t\r\n")
+			if p.buf == "" {
+				return p.result(thinking, content, false)
+			}
+			p.state = parseContent
+		default:
+			content += p.buf
+			p.buf = ""
+			return p.result(thinking, content, false)
+		}
+	}
+}
+
+// Flush returns what was held back, at the end of the response.
+func (p *thinkingParser) Flush() (thinking string, content string) {
+	buf := p.buf
+	p.buf = ""
+	switch {
+	case p.separate:
+	case p.state == parseThinking:
+		thinking = buf
+	case p.state != parseAfter:
+		content = buf
+	}
+	return p.result(thinking, content, true)
+}
+
+// Split returns the thinking and content of a complete response.
+func (p *thinkingParser) Split(reasoning string, text string) (thinking string, content string) {
+	thinking, content = p.Add(reasoning, text)
+	restThinking, restContent := p.Flush()
+	return thinking + restThinking, content + restContent
+}
+
+// result returns the thinking and content for the client. ends marks the end of the
+// response.
+func (p *thinkingParser) result(thinking string, content string, ends bool) (string, string) {
+	switch {
+	case p.drop:
+		return "", content
+	case p.inline:
+		return "", p.tags.content(thinking, content, ends)
+	default:
+		return thinking, content
+	}
+}
+
+// reasoningFields are the reasoning of a message or delta: "reasoning" on OpenRouter,
+// "reasoning_content" on DeepSeek-style APIs.
+type reasoningFields struct {
+	Reasoning        string `json:"reasoning"`
+	ReasoningContent string `json:"reasoning_content"`
+}
+
+func (r reasoningFields) text() string {
+	if r.Reasoning != "" {
+		return r.Reasoning
+	}
+	return r.ReasoningContent
+}
+
+// thinkingTags puts the thinking of stream chunks in front of their content, in
+// <think> tags, for INLINE_THINKING.
+type thinkingTags struct {
+	open bool // Inside the reasoning
+}
+
+// content returns the content of a chunk with its thinking. The thinking ends with
+// the first chunk that has content, or that ends the message.
+func (t *thinkingTags) content(reasoning string, content string, ends bool) string {
+	prefix := ""
+	if reasoning != "" {
+		if !t.open {
+			prefix = thinkOpen
+			t.open = true
+		}
+		prefix += reasoning
+	}
+	if t.open && (content != "" || ends) {
+		prefix += thinkClose
+		t.open = false
+	}
+	return prefix + content
+}
+
+// reasoningStream reads the reasoning deltas go-openai drops from the raw chunks.
+type reasoningStream struct {
+	stream *openai.ChatCompletionStream
+}
+
+func (s *reasoningStream) Recv() (ChatChunk, error) {
+	var chunk ChatChunk
+	raw, err := s.stream.RecvRaw()
+	if err != nil {
+		return chunk, err
+	}
+	if err := json.Unmarshal(raw, &chunk.ChatCompletionStreamResponse); err != nil {
+		return chunk, err
+	}
+
+	var reasoning struct {
+		Choices []struct {
+			Delta reasoningFields `json:"delta"`
+		} `json:"choices"`
+	}
+	json.Unmarshal(raw, &reasoning)
+	if len(reasoning.Choices) > 0 {
+		chunk.Reasoning = reasoning.Choices[0].Delta.text()
+	}
+	return chunk, nil
+}
+
+func (s *reasoningStream) Close() error {
+	return s.stream.Close()
+}
+
+// responseReasoning returns the reasoning of the first choice of a raw chat
+// completion response.
+func responseReasoning(raw []byte) string {
+	var reasoning struct {
+		Choices []struct {
+			Message reasoningFields `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(raw, &reasoning); err != nil || len(reasoning.Choices) == 0 {
+		return ""
+	}
+	return reasoning.Choices[0].Message.text()
+}
+
+type responseCaptureKey struct{}
+
+// withResponseCapture makes responseCaptureTransport store the body of the response
+// to the request made with the context.
+func withResponseCapture(ctx context.Context, body *[]byte) context.Context {
+	return context.WithValue(ctx, responseCaptureKey{}, body)
+}
+
+// Custom transport to keep a copy of response bodies, for fields go-openai drops
+type responseCaptureTransport struct {
+	base http.RoundTripper
+}
+
+func (t *responseCaptureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	body, ok := req.Context().Value(responseCaptureKey{}).(*[]byte)
+	if err != nil || !ok {
+		return resp, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = data
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// thinkingChunk is a piece of a streamed response: the reasoning field and the content.
+type thinkingChunk struct {
+	reasoning string
+	content   string
+}
+
+// splitChunks runs the chunks through the parser and returns all of the thinking and content.
+func splitChunks(parser *thinkingParser, chunks []thinkingChunk) (string, string) {
+	var thinking, content string
+	for _, c := range chunks {
+		t, text := parser.Add(c.reasoning, c.content)
+		thinking += t
+		content += text
+	}
+	t, text := parser.Flush()
+	return thinking + t, content + text
+}
+
+func TestThinkingParser(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunks       []thinkingChunk
+		wantThinking string
+		wantContent  string
+	}{
+		{
+			name:        "no thinking",
+			chunks:      []thinkingChunk{{content: "Hello"}, {content: " there"}},
+			wantContent: "Hello there",
+		},
+		{
+			name:         "reasoning field",
+			chunks:       []thinkingChunk{{reasoning: "Let me "}, {reasoning: "think."}, {content: "Hi"}},
+			wantThinking: "Let me think.",
+			wantContent:  "Hi",
+		},
+		{
+			name:         "tags in the content are the answer with a reasoning field",
+			chunks:       []thinkingChunk{{reasoning: "Quoting."}, {content: "<think>is a tag"}, {content: "</think>"}},
+			wantThinking: "Quoting.",
+			wantContent:  "<think>is a tag</think>",
+		},
+		{
+			name:         "tags",
+			chunks:       []thinkingChunk{{content: "<think>Let me think.</think>\n\nHi"}},
+			wantThinking: "Let me think.",
+			wantContent:  "Hi",
+		},
+		{
+			name:         "tags split across chunks",
+			chunks:       []thinkingChunk{{content: "\n<th"}, {content: "ink>Let me"}, {content: " think.</thi"}, {content: "nk>"}, {content: "\n"}, {content: "Hi"}},
+			wantThinking: "Let me think.",
+			wantContent:  "Hi",
+		},
+		{
+			name:         "partial closing tag inside the thinking",
+			chunks:       []thinkingChunk{{content: "<think>a </"}, {content: "b</think>c"}},
+			wantThinking: "a </b",
+			wantContent:  "c",
+		},
+		{
+			name:        "tag later in the answer",
+			chunks:      []thinkingChunk{{content: "Use "}, {content: "<think> tags"}},
+			wantContent: "Use <think> tags",
+		},
+		{
+			name:        "start of a tag at the end",
+			chunks:      []thinkingChunk{{content: "<thi"}},
+			wantContent: "<thi",
+		},
+		{
+			name:         "unclosed tag",
+			chunks:       []thinkingChunk{{content: "<think>Still thinking"}},
+			wantThinking: "Still thinking",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thinking, content := splitChunks(&thinkingParser{}, tt.chunks)
+			if thinking != tt.wantThinking || content != tt.wantContent {
+				t.Errorf("got thinking %q, content %q, want %q, %q", thinking, content, tt.wantThinking, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestThinkingParserOutput(t *testing.T) {
+	chunks := []thinkingChunk{{reasoning: "Let me "}, {reasoning: "think."}, {content: "Hi"}}
+	tests := []struct {
+		name         string
+		parser       *thinkingParser
+		chunks       []thinkingChunk
+		wantThinking string
+		wantContent  string
+	}{
+		{name: "drop", parser: &thinkingParser{drop: true}, chunks: chunks, wantContent: "Hi"},
+		{name: "inline", parser: &thinkingParser{inline: true}, chunks: chunks, wantContent: "<think>Let me think.</think>Hi"},
+		{name: "inline and drop", parser: &thinkingParser{inline: true, drop: true}, chunks: chunks, wantContent: "Hi"},
+		{
+			name:        "inline tags",
+			parser:      &thinkingParser{inline: true},
+			chunks:      []thinkingChunk{{content: "<think>Let me think.</think>Hi"}},
+			wantContent: "<think>Let me think.</think>Hi",
+		},
+		{
+			name:        "inline without content",
+			parser:      &thinkingParser{inline: true},
+			chunks:      []thinkingChunk{{reasoning: "Only thinking."}},
+			wantContent: "<think>Only thinking.</think>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thinking, content := splitChunks(tt.parser, tt.chunks)
+			if thinking != tt.wantThinking || content != tt.wantContent {
+				t.Errorf("got thinking %q, content %q, want %q, %q", thinking, content, tt.wantThinking, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestThinkOption(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "true", want: `{"enabled":true}`},
+		{value: "false", want: `{"enabled":false}`},
+		{value: `"high"`, want: `{"effort":"high"}`},
+		{value: "2048", want: `{"max_tokens":2048}`},
+		{value: `"max"`, wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "[]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var think thinkOption
+			err := json.Unmarshal([]byte(tt.value), &think)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal() = %+v, want an error", think)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			got, _ := json.Marshal(think.reasoning())
+			if string(got) != tt.want {
+				t.Errorf("reasoning() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	var none *thinkOption
+	if none.reasoning() != nil || none.disabled() {
+		t.Error("without think, no reasoning should be requested")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Model names are resolved in a fixed order: an exact model ID, then a configured
+// alias, then the one model ID that ends in "/<name>". A name that matches several
+// IDs is rejected instead of picking one of them.
+
+// qualifiedModelNames lists OpenRouter models under their full ID, e.g.
+// "deepseek/deepseek-chat" instead of "deepseek-chat".
+var qualifiedModelNames bool
+
+// loadModelNameStyle reads MODEL_NAMES, "short" (the default) or "qualified".
+func loadModelNameStyle() error {
+	switch value := os.Getenv("MODEL_NAMES"); value {
+	case "", "short":
+		qualifiedModelNames = false
+	case "qualified":
+		qualifiedModelNames = true
+	default:
+		return fmt.Errorf("invalid MODEL_NAMES %q, expected \"short\" or \"qualified\"", value)
+	}
+	return nil
+}
+
+// ambiguousModelError is returned for a name that matches several models.
+type ambiguousModelError struct {
+	name       string
+	candidates []string
+}
+
+func (e *ambiguousModelError) Error() string {
+	return fmt.Sprintf("model %q is ambiguous, use one of: %s", e.name, strings.Join(e.candidates, ", "))
+}
+
+// modelErrorStatus returns the HTTP status for a failed model name resolution.
+// Ollama returns 404 for unknown models.
+func modelErrorStatus(err error) int {
+	var ambiguous *ambiguousModelError
+	switch {
+	case errors.As(err, &ambiguous):
+		return http.StatusBadRequest
+	case errors.Is(err, errModelNotAllowed), errors.Is(err, errModelNotAllowedForKey):
+		return http.StatusForbidden
+	}
+	return http.StatusNotFound
+}
+
+// matchModelID returns the model ID a name refers to: the ID itself, or else the
+// only ID ending in "/<name>".
+func matchModelID(ids []string, name string) (string, error) {
+	var candidates []string
+	for _, id := range ids {
+		if id == name {
+			return id, nil
+		}
+		if strings.HasSuffix(id, "/"+name) {
+			candidates = append(candidates, id)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", errModelNotFound, name)
+	case 1:
+		return candidates[0], nil
+	default:
+		sort.Strings(candidates)
+		return "", &ambiguousModelError{name: name, candidates: candidates}
+	}
+}
+
+// shortModelNames returns the names models are listed under: the part after the
+// vendor, unless full IDs are configured or several vendors use the same name.
+func shortModelNames(ids []string) []string {
+	names := make([]string, len(ids))
+	if qualifiedModelNames {
+		copy(names, ids)
+		return names
+	}
+
+	count := make(map[string]int, len(ids))
+	for i, id := range ids {
+		names[i] = id[strings.LastIndex(id, "/")+1:]
+		count[names[i]]++
+	}
+	for i, id := range ids {
+		if count[names[i]] > 1 {
+			names[i] = id
+		}
+	}
+	return names
+}
+
+// modelIDLister is implemented by providers that know the IDs of their models.
+type modelIDLister interface {
+	ModelIDs(ctx context.Context) ([]string, error)
+}
+
+// isModelID reports whether the name is a model ID of the provider.
+func isModelID(ctx context.Context, provider Provider, name string) bool {
+	lister, ok := provider.(modelIDLister)
+	if !ok {
+		return false
+	}
+	ids, err := lister.ModelIDs(ctx)
+	if err != nil {
+		slog.Warn("Failed to list model IDs", "Error", err)
+		return false
+	}
+	return containsString(ids, name)
+}
+
+// lookupAlias returns the configured alias of the name, or nil. Model IDs take
+// precedence over aliases of the same name.
+func lookupAlias(ctx context.Context, provider Provider, name string) *modelAlias {
+	alias := findAlias(name)
+	if alias == nil || isModelID(ctx, provider, name) {
+		return nil
+	}
+	return alias
+}
+
+// resolveModelAlias returns the full model name for a name requested by a client,
+// together with the alias it was requested by, if any.
+func resolveModelAlias(ctx context.Context, provider Provider, name string) (string, *modelAlias, error) {
+	alias := lookupAlias(ctx, provider, name)
+	if alias != nil {
+		slog.Info("Resolved model alias", "alias", name, "model", alias.Model)
+		name = alias.Model
+	}
+	fullName, err := provider.GetFullModelName(ctx, name)
+	return fullName, alias, err
+}
+
+// resolveModel returns the full model name for a name requested by a client.
+func resolveModel(ctx context.Context, provider Provider, name string) (string, error) {
+	fullName, _, err := resolveModelAlias(ctx, provider, name)
+	return fullName, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMatchModelID(t *testing.T) {
+	ids := []string{
+		"deepseek/deepseek-chat",
+		"openai/gpt-4o",
+		"azure/gpt-4o",
+		"meta-llama/llama-3-8b",
+		"llama-3-8b",
+	}
+	tests := []struct {
+		name       string
+		want       string
+		notFound   bool
+		candidates []string
+	}{
+		{name: "deepseek/deepseek-chat", want: "deepseek/deepseek-chat"},
+		{name: "deepseek-chat", want: "deepseek/deepseek-chat"},
+		{name: "llama-3-8b", want: "llama-3-8b"}, // The exact ID wins over the suffix match
+		{name: "gpt-4o", candidates: []string{"azure/gpt-4o", "openai/gpt-4o"}},
+		{name: "chat", notFound: true},
+		{name: "gpt-4", notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchModelID(ids, tt.name)
+			var ambiguous *ambiguousModelError
+			switch {
+			case tt.notFound:
+				if !errors.Is(err, errModelNotFound) {
+					t.Fatalf("matchModelID() error = %v, want errModelNotFound", err)
+				}
+				if status := modelErrorStatus(err); status != http.StatusNotFound {
+					t.Errorf("modelErrorStatus() = %d, want %d", status, http.StatusNotFound)
+				}
+			case tt.candidates != nil:
+				if !errors.As(err, &ambiguous) {
+					t.Fatalf("matchModelID() error = %v, want an ambiguous model error", err)
+				}
+				if !reflect.DeepEqual(ambiguous.candidates, tt.candidates) {
+					t.Errorf("candidates = %v, want %v", ambiguous.candidates, tt.candidates)
+				}
+				if status := modelErrorStatus(err); status != http.StatusBadRequest {
+					t.Errorf("modelErrorStatus() = %d, want %d", status, http.StatusBadRequest)
+				}
+			case err != nil:
+				t.Fatalf("matchModelID() error = %v", err)
+			case got != tt.want:
+				t.Errorf("matchModelID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortModelNames(t *testing.T) {
+	ids := []string{"deepseek/deepseek-chat", "openai/gpt-4o", "azure/gpt-4o", "local"}
+	tests := []struct {
+		qualified bool
+		want      []string
+	}{
+		{want: []string{"deepseek-chat", "openai/gpt-4o", "azure/gpt-4o", "local"}},
+		{qualified: true, want: ids},
+	}
+	defer func(qualified bool) { qualifiedModelNames = qualified }(qualifiedModelNames)
+	for _, tt := range tests {
+		qualifiedModelNames = tt.qualified
+		if got := shortModelNames(ids); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shortModelNames() with qualified %v = %v, want %v", tt.qualified, got, tt.want)
+		}
+	}
+}
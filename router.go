@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Route match types
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchGlob   = "glob"
+	MatchRegex  = "regex"
+)
+
+// RouteRule sends the models whose name matches the pattern to a backend.
+type RouteRule struct {
+	Match   string `json:"match"`
+	Pattern string `json:"pattern"`
+	Backend string `json:"backend"`
+	// Only for prefix rules: remove the prefix before the name is sent upstream,
+	// and add it to the backend's models in /api/tags
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// RoutingConfig is the content of the routes file.
+type RoutingConfig struct {
+	Backends []BackendConfig `json:"backends"`
+	Routes   []RouteRule     `json:"routes"`
+	Default  string          `json:"default"`
+}
+
+func loadRoutingConfig(file string) (*RoutingConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config RoutingConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return &config, nil
+}
+
+// matches reports whether the rule applies to the model name.
+func (r *RouteRule) matches(model string) bool {
+	switch r.Match {
+	case MatchExact:
+		return model == r.Pattern
+	case MatchPrefix:
+		return strings.HasPrefix(model, r.Pattern)
+	case MatchGlob:
+		ok, _ := path.Match(r.Pattern, model)
+		return ok
+	case MatchRegex:
+		return r.regex.MatchString(model)
+	}
+	return false
+}
+
+// upstreamName returns the model name as the backend knows it.
+func (r *RouteRule) upstreamName(model string) string {
+	if r.Match == MatchPrefix && r.StripPrefix {
+		return strings.TrimPrefix(model, r.Pattern)
+	}
+	return model
+}
+
+// routedBackend is a backend together with the prefix its model names carry in the proxy.
+type routedBackend struct {
+	name     string
+	provider Provider
+	prefix   string
+}
+
+// Router is a Provider that sends each request to the backend selected by ordered
+// routing rules. Rules are checked top to bottom; the first match wins.
+type Router struct {
+	backends       []*routedBackend // In configuration order
+	backendsByName map[string]*routedBackend
+	rules          []RouteRule
+	fallback       *routedBackend
+
+	// Full names that a backend other than the default resolved, but that the rules
+	// would send elsewhere, so Chat and friends still go to the resolving backend
+	resolvedMu sync.Mutex
+	resolved   map[string]*routedBackend // full name -> backend
+}
+
+// Most full names remembered for the backends that resolved them
+const maxResolvedModels = 1000
+
+// NewRouter creates the backends of the routing configuration. OpenRouter backends
+// without their own key use the proxy's OpenRouter key.
+func NewRouter(config *RoutingConfig, openrouterAPIKey string) (*Router, error) {
+	router := &Router{backendsByName: make(map[string]*routedBackend), resolved: make(map[string]*routedBackend)}
+
+	for _, backendConfig := range config.Backends {
+		if backendConfig.Name == "" {
+			return nil, errors.New("every backend needs a name")
+		}
+		if _, exists := router.backendsByName[backendConfig.Name]; exists {
+			return nil, fmt.Errorf("duplicate backend %q", backendConfig.Name)
+		}
+		if backendConfig.Type == BackendOpenrouter && backendConfig.APIKey == "" && backendConfig.APIKeyEnv == "" {
+			backendConfig.APIKey = openrouterAPIKey
+		}
+
+		provider, err := NewProvider(backendConfig)
+		if err != nil {
+			return nil, err
+		}
+		backend := &routedBackend{name: backendConfig.Name, provider: provider}
+		router.backends = append(router.backends, backend)
+		router.backendsByName[backend.name] = backend
+	}
+	if len(router.backends) == 0 {
+		return nil, errors.New("no backends configured")
+	}
+
+	for i, rule := range config.Routes {
+		backend, ok := router.backendsByName[rule.Backend]
+		if !ok {
+			return nil, fmt.Errorf("route %d: unknown backend %q", i+1, rule.Backend)
+		}
+		switch rule.Match {
+		case MatchExact, MatchPrefix:
+		case MatchGlob:
+			if _, err := path.Match(rule.Pattern, ""); err != nil {
+				return nil, fmt.Errorf("route %d: invalid glob %q: %w", i+1, rule.Pattern, err)
+			}
+		case MatchRegex:
+			regex, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: invalid regex %q: %w", i+1, rule.Pattern, err)
+			}
+			rule.regex = regex
+		default:
+			return nil, fmt.Errorf("route %d: unknown match type %q", i+1, rule.Match)
+		}
+		if rule.StripPrefix {
+			if rule.Match != MatchPrefix {
+				return nil, fmt.Errorf("route %d: strip_prefix only works with prefix rules", i+1)
+			}
+			if backend.prefix == "" {
+				backend.prefix = rule.Pattern
+			}
+		}
+		router.rules = append(router.rules, rule)
+	}
+
+	// Unmatched models go to the default backend, or to the first one if none is set
+	router.fallback = router.backends[0]
+	if config.Default != "" {
+		backend, ok := router.backendsByName[config.Default]
+		if !ok {
+			return nil, fmt.Errorf("unknown default backend %q", config.Default)
+		}
+		router.fallback = backend
+	}
+
+	return router, nil
+}
+
+// route selects the backend for a model name and returns the name to send upstream.
+func (r *Router) route(model string) (*routedBackend, string) {
+	r.resolvedMu.Lock()
+	backend, ok := r.resolved[model]
+	r.resolvedMu.Unlock()
+	if ok {
+		return backend, strings.TrimPrefix(model, backend.prefix)
+	}
+	return r.routeByRules(model)
+}
+
+// routeByRules is route without the names resolved by GetFullModelName.
+func (r *Router) routeByRules(model string) (*routedBackend, string) {
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if rule.matches(model) {
+			return r.backendsByName[rule.Backend], rule.upstreamName(model)
+		}
+	}
+	return r.fallback, model
+}
+
+func (r *Router) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatResponse, error) {
+	backend, upstreamName := r.route(modelName)
+	return backend.provider.Chat(ctx, messages, upstreamName, opts)
+}
+
+func (r *Router) ChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	backend, upstreamName := r.route(modelName)
+	return backend.provider.ChatStream(ctx, messages, upstreamName, opts)
+}
+
+func (r *Router) Generate(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatResponse, error) {
+	backend, upstreamName := r.route(modelName)
+	return backend.provider.Generate(ctx, prompt, upstreamName, systemPrompt, images, opts)
+}
+
+func (r *Router) GenerateStream(ctx context.Context, prompt string, modelName string, systemPrompt string, images []string, opts RequestOptions) (ChatCompletionStream, error) {
+	backend, upstreamName := r.route(modelName)
+	return backend.provider.GenerateStream(ctx, prompt, upstreamName, systemPrompt, images, opts)
+}
+
+func (r *Router) Complete(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatResponse, error) {
+	backend, upstreamName := r.route(modelName)
+	return complete(ctx, backend.provider, prompt, suffix, upstreamName, opts)
+}
+
+func (r *Router) CompleteStream(ctx context.Context, prompt string, suffix string, modelName string, opts RequestOptions) (ChatCompletionStream, error) {
+	backend, upstreamName := r.route(modelName)
+	return completeStream(ctx, backend.provider, prompt, suffix, upstreamName, opts)
+}
+
+func (r *Router) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest, truncate bool) (openai.EmbeddingResponse, error) {
+	backend, upstreamName := r.route(string(req.Model))
+	req.Model = openai.EmbeddingModel(upstreamName)
+	return createEmbeddings(ctx, backend.provider, req, truncate)
+}
+
+// SupportsNativeFallback reports whether all models go to the same backend and it can
+// fall back between them itself. Names are passed upstream as they are, so the backend
+// must not use a prefix.
+func (r *Router) SupportsNativeFallback(models []string) bool {
+	backend, _ := r.route(models[0])
+	if backend.prefix != "" {
+		return false
+	}
+	for _, model := range models[1:] {
+		if other, _ := r.route(model); other != backend {
+			return false
+		}
+	}
+	native, ok := backend.provider.(nativeFallbackProvider)
+	return ok && native.SupportsNativeFallback(models)
+}
+
+// GetModels merges the model lists of all backends. A model offered by several
+// backends is listed once, for the backend configured first. Unreachable backends
+// are skipped as long as at least one backend answers.
+func (r *Router) GetModels(ctx context.Context) ([]Model, error) {
+	type result struct {
+		models []Model
+		err    error
+	}
+	results := make([]result, len(r.backends))
+
+	var wg sync.WaitGroup
+	for i, backend := range r.backends {
+		wg.Add(1)
+		go func(i int, backend *routedBackend) {
+			defer wg.Done()
+			models, err := backend.provider.GetModels(ctx)
+			results[i] = result{models: models, err: err}
+		}(i, backend)
+	}
+	wg.Wait()
+
+	var models []Model
+	var errs []error
+	seen := make(map[string]struct{})
+	for i, backend := range r.backends {
+		if results[i].err != nil {
+			slog.Error("Error getting models from backend", "backend", backend.name, "Error", results[i].err)
+			errs = append(errs, fmt.Errorf("%s: %w", backend.name, results[i].err))
+			continue
+		}
+		for _, model := range results[i].models {
+			if backend.prefix != "" {
+				model.Name = backend.prefix + model.Name
+				model.Model = backend.prefix + model.Model
+				if model.id != "" {
+					model.id = backend.prefix + model.id
+				}
+			}
+			if _, ok := seen[model.Name]; ok {
+				continue
+			}
+			seen[model.Name] = struct{}{}
+			models = append(models, model)
+		}
+	}
+
+	if len(errs) == len(r.backends) {
+		return nil, errors.Join(errs...)
+	}
+	return models, nil
+}
+
+// RefreshModels refreshes the model lists of all backends that cache them.
+func (r *Router) RefreshModels(ctx context.Context) error {
+	var errs []error
+	for _, backend := range r.backends {
+		if err := refreshModels(ctx, backend.provider); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ModelIDs returns the model IDs of all backends that know them, with their prefixes.
+func (r *Router) ModelIDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	for _, backend := range r.backends {
+		lister, ok := backend.provider.(modelIDLister)
+		if !ok {
+			continue
+		}
+		backendIDs, err := lister.ModelIDs(ctx)
+		if err != nil {
+			slog.Warn("Failed to list model IDs of backend", "backend", backend.name, "Error", err)
+			continue
+		}
+		for _, id := range backendIDs {
+			ids = append(ids, backend.prefix+id)
+		}
+	}
+	return ids, nil
+}
+
+// CatalogModel returns the catalog entry of a model from the backend it routes to.
+func (r *Router) CatalogModel(ctx context.Context, id string) *openrouterModel {
+	backend, upstreamName := r.route(id)
+	return catalogModel(ctx, backend.provider, upstreamName)
+}
+
+func (r *Router) GetModelDetails(ctx context.Context, modelName string) (map[string]interface{}, error) {
+	backend, upstreamName := r.route(modelName)
+	return backend.provider.GetModelDetails(ctx, upstreamName)
+}
+
+func (r *Router) GetFullModelName(ctx context.Context, alias string) (string, error) {
+	backend, upstreamName := r.route(alias)
+	fullName, err := backend.provider.GetFullModelName(ctx, upstreamName)
+	if err != nil {
+		return "", err
+	}
+
+	fullName = backend.prefix + fullName
+	if routed, _ := r.routeByRules(fullName); backend != r.fallback && routed != backend {
+		r.rememberResolved(fullName, backend)
+	}
+	slog.Info("Routed model", "model", alias, "backend", backend.name, "fullModelName", fullName)
+	return fullName, nil
+}
+
+// rememberResolved sends a full name to the backend that resolved it from now on.
+// Once the limit is reached, further names are routed by the rules only.
+func (r *Router) rememberResolved(fullName string, backend *routedBackend) {
+	r.resolvedMu.Lock()
+	defer r.resolvedMu.Unlock()
+	if _, ok := r.resolved[fullName]; !ok && len(r.resolved) >= maxResolvedModels {
+		slog.Warn("Too many resolved model names, routing by the rules only", "model", fullName, "backend", backend.name)
+		return
+	}
+	r.resolved[fullName] = backend
+}
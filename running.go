@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultKeepAlive is how long a model counts as loaded after a request without
+// keep_alive, like Ollama's OLLAMA_KEEP_ALIVE.
+var defaultKeepAlive = 5 * time.Minute
+
+// keepAlive is Ollama's keep_alive: a duration like "5m" or a number of seconds.
+// Negative values keep the model loaded forever, zero unloads it right away.
+type keepAlive time.Duration
+
+func (k *keepAlive) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*k = keepAlive(time.Duration(v * float64(time.Second)))
+	case string:
+		duration, err := parseKeepAlive(v)
+		if err != nil {
+			return err
+		}
+		*k = keepAlive(duration)
+	default:
+		return fmt.Errorf("keep_alive must be a duration or a number of seconds, got %T", value)
+	}
+	return nil
+}
+
+// parseKeepAlive parses a keep_alive string; plain numbers are seconds.
+func parseKeepAlive(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keep_alive %q", value)
+	}
+	return duration, nil
+}
+
+// loadKeepAlive reads the default keep alive from OLLAMA_KEEP_ALIVE.
+func loadKeepAlive() error {
+	value := os.Getenv("OLLAMA_KEEP_ALIVE")
+	if value == "" {
+		return nil
+	}
+	duration, err := parseKeepAlive(value)
+	if err != nil {
+		return err
+	}
+	defaultKeepAlive = duration
+	return nil
+}
+
+// duration returns the keep alive of a request, the default if it has none.
+func (k *keepAlive) duration() time.Duration {
+	if k == nil {
+		return defaultKeepAlive
+	}
+	return time.Duration(*k)
+}
+
+// doneReason is the done_reason of a request that only loads or unloads a model.
+func (k *keepAlive) doneReason() string {
+	if k.duration() == 0 {
+		return "unload"
+	}
+	return "load"
+}
+
+// loadedModels tracks the models used recently, for /api/ps.
+var loadedModels = &modelTracker{models: make(map[string]*loadedModel)}
+
+// loadedModel is a model that counts as loaded until it expires.
+type loadedModel struct {
+	name          string
+	details       ModelDetails
+	contextLength int
+	expiresAt     time.Time
+}
+
+// modelTracker stands in for Ollama's model scheduler: remote models are never
+// really loaded, but clients expect used models to show up in /api/ps.
+type modelTracker struct {
+	mu     sync.Mutex
+	models map[string]*loadedModel // By the name the model was requested under
+}
+
+// maxLoadedModels caps the models listed in /api/ps, so requests for many
+// names or with a negative keep_alive cannot grow it without bound.
+const maxLoadedModels = 100
+
+// Touch records a successful request for the model. It stays listed for the
+// request's keep alive; a keep alive of zero removes it.
+func (t *modelTracker) Touch(ctx context.Context, provider Provider, name string, fullName string, keep *keepAlive) {
+	duration := keep.duration()
+	if duration == 0 {
+		t.mu.Lock()
+		delete(t.models, name)
+		t.mu.Unlock()
+		return
+	}
+
+	model := &loadedModel{name: name, details: ModelDetails{Format: "api"}}
+	if meta := catalogModel(ctx, provider, fullName); meta != nil {
+		model.details = meta.details()
+		model.contextLength = meta.contextLength()
+	}
+	if duration < 0 {
+		model.expiresAt = time.Now().Add(time.Duration(math.MaxInt64))
+	} else {
+		model.expiresAt = time.Now().Add(duration)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.models[name]; !ok && len(t.models) >= maxLoadedModels {
+		t.evict()
+	}
+	t.models[name] = model
+}
+
+// evict drops the expired models, or if none has expired, the one that expires
+// first.
+func (t *modelTracker) evict() {
+	now := time.Now()
+	var first *loadedModel
+	for name, model := range t.models {
+		if now.After(model.expiresAt) {
+			delete(t.models, name)
+		} else if first == nil || model.expiresAt.Before(first.expiresAt) {
+			first = model
+		}
+	}
+	if len(t.models) >= maxLoadedModels && first != nil {
+		delete(t.models, first.name)
+	}
+}
+
+// List returns the models that have not expired, the most recently used first.
+func (t *modelTracker) List() []*loadedModel {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	models := make([]*loadedModel, 0, len(t.models))
+	for name, model := range t.models {
+		if now.After(model.expiresAt) {
+			delete(t.models, name)
+			continue
+		}
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].expiresAt.After(models[j].expiresAt)
+	})
+	return models
+}
+
+// registerRunningRoutes adds /api/ps.
+func registerRunningRoutes(r *gin.Engine) {
+	r.GET("/api/ps", func(c *gin.Context) {
+		models := make([]gin.H, 0)
+		for _, model := range loadedModels.List() {
+			entry := gin.H{
+				"name":       model.name,
+				"model":      model.name,
+				"size":       0,
+				"digest":     model.name,
+				"details":    model.details,
+				"expires_at": model.expiresAt.Format(time.RFC3339Nano),
+				"size_vram":  0,
+			}
+			if model.contextLength > 0 {
+				entry["context_length"] = model.contextLength
+			}
+			models = append(models, entry)
+		}
+		c.JSON(http.StatusOK, gin.H{"models": models})
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestModelTrackerCap(t *testing.T) {
+	tracker := &modelTracker{models: make(map[string]*loadedModel)}
+	now := time.Now()
+	for i := 0; i < maxLoadedModels; i++ {
+		name := fmt.Sprintf("model-%d", i)
+		tracker.models[name] = &loadedModel{name: name, expiresAt: now.Add(time.Hour + time.Duration(i)*time.Second)}
+	}
+
+	forever := keepAlive(-1)
+	tracker.Touch(context.Background(), nil, "new", "new", &forever)
+	if len(tracker.models) != maxLoadedModels {
+		t.Fatalf("tracked %d models, want %d", len(tracker.models), maxLoadedModels)
+	}
+	if _, ok := tracker.models["model-0"]; ok {
+		t.Error("the model that expires first was kept")
+	}
+	if _, ok := tracker.models["new"]; !ok {
+		t.Error("the new model was not tracked")
+	}
+
+	tracker.models["model-1"].expiresAt = now.Add(-time.Second)
+	tracker.models["model-2"].expiresAt = now.Add(-time.Second)
+	tracker.Touch(context.Background(), nil, "newer", "newer", nil)
+	if len(tracker.models) != maxLoadedModels-1 {
+		t.Errorf("tracked %d models, want %d after dropping the expired ones", len(tracker.models), maxLoadedModels-1)
+	}
+	if _, ok := tracker.models["model-3"]; !ok {
+		t.Error("a model that had not expired was dropped")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// generationStats measures a request the way Ollama reports it in the final response.
+// All durations are wall-clock nanoseconds as seen by the proxy.
+type generationStats struct {
+	start        time.Time
+	streamOpened time.Time // Upstream accepted the request
+	firstToken   time.Time // First content or tool call chunk arrived
+	end          time.Time
+	usage        *openai.Usage
+}
+
+func newGenerationStats() *generationStats {
+	return &generationStats{start: time.Now()}
+}
+
+// StreamOpened records that the upstream request was accepted and the response started.
+func (s *generationStats) StreamOpened() {
+	s.streamOpened = time.Now()
+}
+
+// Chunk records a streamed chunk, keeping the time of the first token and the usage
+// which is only present on the last chunk.
+func (s *generationStats) Chunk(response ChatChunk) {
+	if response.Usage != nil {
+		s.usage = response.Usage
+	}
+	if !s.firstToken.IsZero() || len(response.Choices) == 0 {
+		return
+	}
+	delta := response.Choices[0].Delta
+	if delta.Content != "" || response.Reasoning != "" || len(delta.ToolCalls) > 0 {
+		s.firstToken = time.Now()
+	}
+}
+
+// SetUsage records the usage of a non-streaming response.
+func (s *generationStats) SetUsage(usage openai.Usage) {
+	s.usage = &usage
+}
+
+// Done marks the end of the generation.
+func (s *generationStats) Done() {
+	s.end = time.Now()
+}
+
+// Fields returns the Ollama statistics fields for the final response.
+func (s *generationStats) Fields() map[string]interface{} {
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	streamOpened := s.streamOpened
+	if streamOpened.IsZero() {
+		streamOpened = s.start
+	}
+	// Without a streamed first token the whole response time counts as generation
+	firstToken := s.firstToken
+	if firstToken.IsZero() {
+		firstToken = streamOpened
+	}
+
+	promptTokens, completionTokens := 0, 0
+	if s.usage != nil {
+		promptTokens = s.usage.PromptTokens
+		completionTokens = s.usage.CompletionTokens
+	}
+
+	return map[string]interface{}{
+		"total_duration":       end.Sub(s.start).Nanoseconds(),
+		"load_duration":        streamOpened.Sub(s.start).Nanoseconds(),
+		"prompt_eval_count":    promptTokens,
+		"prompt_eval_duration": firstToken.Sub(streamOpened).Nanoseconds(),
+		"eval_count":           completionTokens,
+		"eval_duration":        end.Sub(firstToken).Nanoseconds(),
+	}
+}
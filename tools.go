@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OllamaToolCall mirrors the tool call object used in Ollama's /api/chat messages.
+// Unlike OpenAI, Ollama sends the arguments as a JSON object and has no call IDs.
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+type OllamaToolCallFunction struct {
+	Index     int             `json:"index,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// toOpenAIToolCalls converts the tool calls of an Ollama history message into
+// OpenAI tool calls. Ollama has no call IDs, so they are synthesized from the
+// message and call positions to let later tool messages reference them.
+func toOpenAIToolCalls(calls []OllamaToolCall, messageIndex int) []openai.ToolCall {
+	toolCalls := make([]openai.ToolCall, 0, len(calls))
+	for i, call := range calls {
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:   fmt.Sprintf("call_%d_%d", messageIndex, i),
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: toolArgumentsToString(call.Function.Arguments),
+			},
+		})
+	}
+	return toolCalls
+}
+
+// toolArgumentsToString turns Ollama's object arguments into the JSON string
+// OpenAI expects. Arguments that are already a JSON string are unquoted.
+func toolArgumentsToString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "{}"
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// toOllamaToolCalls converts OpenAI tool calls returned by the model back into
+// Ollama's format, decoding the argument string into a JSON object.
+func toOllamaToolCalls(calls []openai.ToolCall) []OllamaToolCall {
+	toolCalls := make([]OllamaToolCall, 0, len(calls))
+	for i, call := range calls {
+		args := json.RawMessage(call.Function.Arguments)
+		if len(args) == 0 {
+			args = json.RawMessage("{}")
+		} else if !json.Valid(args) {
+			slog.Warn("Model returned tool call with invalid JSON arguments",
+				"tool", call.Function.Name,
+				"arguments", call.Function.Arguments)
+			args = json.RawMessage("{}")
+		}
+		toolCalls = append(toolCalls, OllamaToolCall{
+			Function: OllamaToolCallFunction{
+				Index:     i,
+				Name:      call.Function.Name,
+				Arguments: args,
+			},
+		})
+	}
+	return toolCalls
+}
+
+// resolveToolCallIDs links "tool" role messages to the assistant tool calls they
+// answer. Ollama clients identify results by tool_name (or only by order), while
+// OpenAI requires a tool_call_id on every tool message.
+func resolveToolCallIDs(messages []openai.ChatCompletionMessage, toolNames []string) {
+	var pending []openai.ToolCall
+	for i := range messages {
+		msg := &messages[i]
+		if msg.Role == openai.ChatMessageRoleAssistant && len(msg.ToolCalls) > 0 {
+			pending = append([]openai.ToolCall(nil), msg.ToolCalls...)
+			continue
+		}
+		if msg.Role != openai.ChatMessageRoleTool || msg.ToolCallID != "" {
+			continue
+		}
+		if len(pending) == 0 {
+			slog.Warn("Tool message without a preceding tool call", "messageIndex", i)
+			continue
+		}
+
+		// Prefer a pending call with the same function name, otherwise take the oldest one
+		match := 0
+		if toolNames[i] != "" {
+			for j, call := range pending {
+				if call.Function.Name == toolNames[i] {
+					match = j
+					break
+				}
+			}
+		}
+		msg.ToolCallID = pending[match].ID
+		pending = append(pending[:match], pending[match+1:]...)
+	}
+}
+
+// toolCallAccumulator collects streamed tool call fragments. OpenAI-style streams
+// send the name and id once and the arguments in pieces, keyed by index. Fragments
+// without an index continue the last call, unless they start a new one with an id.
+type toolCallAccumulator struct {
+	calls map[int]*openai.ToolCall
+	last  int // Index of the last fragment, -1 before the first
+}
+
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{calls: make(map[int]*openai.ToolCall), last: -1}
+}
+
+func (a *toolCallAccumulator) Add(deltas []openai.ToolCall) {
+	for _, delta := range deltas {
+		index := a.last
+		switch {
+		case delta.Index != nil:
+			index = *delta.Index
+		case delta.ID != "" || index < 0:
+			index = a.nextIndex()
+		}
+		a.last = index
+		call, ok := a.calls[index]
+		if !ok {
+			call = &openai.ToolCall{Type: openai.ToolTypeFunction}
+			a.calls[index] = call
+		}
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name += delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+}
+
+// nextIndex returns an index after those of all calls so far.
+func (a *toolCallAccumulator) nextIndex() int {
+	next := 0
+	for index := range a.calls {
+		if index >= next {
+			next = index + 1
+		}
+	}
+	return next
+}
+
+func (a *toolCallAccumulator) Empty() bool {
+	return len(a.calls) == 0
+}
+
+// ToolCalls returns the accumulated calls ordered by their stream index.
+func (a *toolCallAccumulator) ToolCalls() []openai.ToolCall {
+	indexes := make([]int, 0, len(a.calls))
+	for index := range a.calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	toolCalls := make([]openai.ToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		toolCalls = append(toolCalls, *a.calls[index])
+	}
+	return toolCalls
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestToolCallAccumulator(t *testing.T) {
+	index := func(i int) *int { return &i }
+	fragment := func(i *int, id, name, arguments string) openai.ToolCall {
+		return openai.ToolCall{Index: i, ID: id, Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+	}
+	call := func(id, name, arguments string) openai.ToolCall {
+		return openai.ToolCall{ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+	}
+
+	tests := []struct {
+		name      string
+		fragments []openai.ToolCall
+		want      []openai.ToolCall
+	}{
+		{
+			name: "indexed",
+			fragments: []openai.ToolCall{
+				fragment(index(0), "a", "get_weather", `{"city"`),
+				fragment(index(1), "b", "get_time", `{}`),
+				fragment(index(0), "", "", `:"Paris"}`),
+			},
+			want: []openai.ToolCall{call("a", "get_weather", `{"city":"Paris"}`), call("b", "get_time", `{}`)},
+		},
+		{
+			name: "without index",
+			fragments: []openai.ToolCall{
+				fragment(nil, "a", "get_weather", `{"city"`),
+				fragment(nil, "", "", `:"Paris"}`),
+				fragment(nil, "b", "get_time", `{`),
+				fragment(nil, "", "", `}`),
+			},
+			want: []openai.ToolCall{call("a", "get_weather", `{"city":"Paris"}`), call("b", "get_time", `{}`)},
+		},
+		{
+			name: "first fragment without id",
+			fragments: []openai.ToolCall{
+				fragment(nil, "", "get_time", `{`),
+				fragment(nil, "", "", `}`),
+			},
+			want: []openai.ToolCall{call("", "get_time", `{}`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accumulator := newToolCallAccumulator()
+			for _, fragment := range tt.fragments {
+				accumulator.Add([]openai.ToolCall{fragment})
+			}
+			if got := accumulator.ToolCalls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ToolCalls() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default limit for a single upstream request, 0 means no limit
+var upstreamTimeout time.Duration
+
+// Header that lets a client set the upstream timeout of its own request, e.g. "90s"
+const upstreamTimeoutHeader = "X-Upstream-Timeout"
+
+// loadUpstreamTimeout reads the global upstream timeout from the UPSTREAM_TIMEOUT environment variable.
+func loadUpstreamTimeout() (time.Duration, error) {
+	value := os.Getenv("UPSTREAM_TIMEOUT")
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, errors.New("timeout must not be negative")
+	}
+	return timeout, nil
+}
+
+// upstreamContext derives the context for upstream calls from the client request,
+// so that a client disconnect aborts the upstream request as well.
+func upstreamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	timeout := upstreamTimeout
+	if value := c.GetHeader(upstreamTimeoutHeader); value != "" {
+		if requestTimeout, err := time.ParseDuration(value); err == nil && requestTimeout > 0 {
+			timeout = requestTimeout
+		} else {
+			slog.Warn("Invalid upstream timeout header, using default", "header", upstreamTimeoutHeader, "value", value)
+		}
+	}
+
+	// The usage of the upstream requests is attributed to the client
+	ctx := withUsageLabels(c.Request.Context(), requestUsageLabels(c))
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// handleUpstreamError logs an error from an upstream call and answers the client.
+// Client cancellations and timeouts are reported separately from real failures.
+func handleUpstreamError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Nobody is listening anymore, so there is nothing to respond
+		slog.Info("Client cancelled request, upstream request aborted", "path", c.FullPath())
+		c.Abort()
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("Upstream request timed out", "path", c.FullPath(), "Error", err)
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "upstream request timed out"})
+	case errors.Is(err, errEmbeddingsNotSupported), errors.Is(err, errCompletionsNotSupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	default:
+		slog.Error(message, "Error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// logStreamError logs an error that ended an upstream stream and reports whether
+// the client is still there to receive an error message.
+func logStreamError(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Info("Client disconnected, upstream stream aborted")
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("Upstream stream timed out", "Error", err)
+	default:
+		slog.Error("Backend stream error", "Error", err)
+	}
+	return true
+}
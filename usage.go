@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageLog sums up the usage and cost of the upstream generations, for /proxy/usage.
+var usageLog = newUsageStore("")
+
+// usageRecord is the usage of one upstream generation. Retries and fallbacks are
+// separate generations, as each of them is billed.
+type usageRecord struct {
+	Time             time.Time `json:"time"`
+	GenerationID     string    `json:"generation_id,omitempty"`
+	Model            string    `json:"model"`
+	Provider         string    `json:"provider,omitempty"` // Provider that served it on OpenRouter
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CachedTokens     int       `json:"cached_tokens"`
+	Cost             float64   `json:"cost"` // USD
+	APIKey           string    `json:"api_key,omitempty"`
+	Client           string    `json:"client,omitempty"`
+}
+
+// usageStore sums up the usage records by all dimensions, day included, so memory
+// grows with the number of models, keys and clients per day rather than with the
+// number of requests. The records themselves are appended to a JSON lines file if
+// the store has one.
+type usageStore struct {
+	path string
+
+	mu     sync.Mutex
+	totals map[usageKey]*usageTotals
+}
+
+// usageKey is a combination of the values of all dimensions.
+type usageKey struct {
+	Day      string // YYYY-MM-DD, local time
+	Model    string
+	Provider string
+	APIKey   string
+	Client   string
+}
+
+func newUsageStore(path string) *usageStore {
+	return &usageStore{path: path, totals: make(map[usageKey]*usageTotals)}
+}
+
+// loadUsageStore sums up the usage records of the file; a missing file starts empty.
+// Lines that cannot be read are skipped with a warning.
+func loadUsageStore(path string) (*usageStore, error) {
+	store := newUsageStore(path)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			slog.Warn("Skipping invalid usage record", "file", path, "line", line, "Error", err)
+			continue
+		}
+		store.count(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// count adds a record to the totals.
+func (s *usageStore) count(record usageRecord) {
+	key := usageKey{
+		Day:      record.Time.Local().Format("2006-01-02"),
+		Model:    record.Model,
+		Provider: record.Provider,
+		APIKey:   record.APIKey,
+		Client:   record.Client,
+	}
+	totals, ok := s.totals[key]
+	if !ok {
+		totals = &usageTotals{}
+		s.totals[key] = totals
+	}
+	totals.add(record)
+}
+
+// Add counts a record and appends it to the file.
+func (s *usageStore) Add(record usageRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count(record)
+	if s.path == "" {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Error encoding usage record", "Error", err)
+		return
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Error("Error saving usage record", "file", s.path, "Error", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		slog.Error("Error saving usage record", "file", s.path, "Error", err)
+	}
+}
+
+// usageDimensions are what /proxy/usage can group the records by.
+var usageDimensions = map[string]func(key usageKey) string{
+	"day":      func(key usageKey) string { return key.Day },
+	"model":    func(key usageKey) string { return key.Model },
+	"provider": func(key usageKey) string { return key.Provider },
+	"api_key":  func(key usageKey) string { return key.APIKey },
+	"client":   func(key usageKey) string { return key.Client },
+}
+
+// usageTotals sums up usage records.
+type usageTotals struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (t *usageTotals) add(record usageRecord) {
+	t.Requests++
+	t.PromptTokens += record.PromptTokens
+	t.CompletionTokens += record.CompletionTokens
+	t.CachedTokens += record.CachedTokens
+	t.Cost += record.Cost
+}
+
+func (t *usageTotals) merge(other *usageTotals) {
+	t.Requests += other.Requests
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.CachedTokens += other.CachedTokens
+	t.Cost += other.Cost
+}
+
+// usageGroup is the usage of the records with the same values of the dimensions.
+type usageGroup struct {
+	keys []string
+	usageTotals
+}
+
+// Summary sums up the usage of the days from "from" to "to", both included and
+// empty for no limit, by the values of the dimensions, sorted by them.
+func (s *usageStore) Summary(groupBy []string, from string, to string) ([]usageGroup, usageTotals) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total usageTotals
+	groups := make(map[string]*usageGroup)
+	for key, totals := range s.totals {
+		if (from != "" && key.Day < from) || (to != "" && key.Day > to) {
+			continue
+		}
+		keys := make([]string, len(groupBy))
+		for i, dimension := range groupBy {
+			keys[i] = usageDimensions[dimension](key)
+		}
+		id := strings.Join(keys, "\x00")
+		group, ok := groups[id]
+		if !ok {
+			group = &usageGroup{keys: keys}
+			groups[id] = group
+		}
+		group.merge(totals)
+		total.merge(totals)
+	}
+
+	result := make([]usageGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		for k := range groupBy {
+			if result[i].keys[k] != result[j].keys[k] {
+				return result[i].keys[k] < result[j].keys[k]
+			}
+		}
+		return false
+	})
+	return result, total
+}
+
+// Header that names the client in the usage records; the client IP is used otherwise
+const usageClientHeader = "X-Client-Name"
+
+// usageLabels say whom the generations of a request are attributed to.
+type usageLabels struct {
+	APIKey string
+	Client string
+}
+
+type usageLabelsKey struct{}
+
+// withUsageLabels attaches the labels for the usage records of upstream requests.
+func withUsageLabels(ctx context.Context, labels usageLabels) context.Context {
+	return context.WithValue(ctx, usageLabelsKey{}, labels)
+}
+
+// requestUsageLabels returns the labels of a client request. API keys are recorded
+// by their name, or a fingerprint without authentication, never in full.
+func requestUsageLabels(c *gin.Context) usageLabels {
+	labels := usageLabels{Client: c.GetHeader(usageClientHeader)}
+	if labels.Client == "" {
+		labels.Client = c.ClientIP()
+	}
+	if key := requestKey(c.Request.Context()); key != nil {
+		labels.APIKey = key.Name
+	} else if key := requestToken(c); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		labels.APIKey = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	return labels
+}
+
+// newUsageRecord starts the usage record of a generation, attributed by the labels
+// of the request. Backends whose responses the usageTransport does not read, i.e.
+// Anthropic and Ollama, fill in and add the record themselves.
+func newUsageRecord(ctx context.Context) usageRecord {
+	labels, _ := ctx.Value(usageLabelsKey{}).(usageLabels)
+	return usageRecord{Time: time.Now(), APIKey: labels.APIKey, Client: labels.Client}
+}
+
+// Delay before the first lookup of a generation's stats, doubled for each retry
+var generationLookupDelay = time.Second
+
+const generationLookupAttempts = 5
+
+// Generations whose stats are looked up wait in a queue for a few workers. When the
+// queue is full, generations are recorded without cost.
+const (
+	generationLookupWorkers = 4
+	generationLookupQueue   = 1000
+)
+
+type generationLookup struct {
+	transport *usageTransport
+	record    usageRecord
+}
+
+var (
+	generationLookups     = make(chan generationLookup, generationLookupQueue)
+	generationLookupsOnce sync.Once
+)
+
+// Custom transport to record the usage of chat and text completions. It reads the
+// generation ID and usage from the response as the client reads it, streams
+// included. OpenRouter reports the cost in the usage; for generations without it,
+// e.g. streams the client aborted, the stats are looked up at generationURL.
+type usageTransport struct {
+	base          http.RoundTripper
+	generationURL string // OpenRouter's /generation, empty for other backends
+	apiKey        string
+}
+
+func (t *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 300 || req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/completions") {
+		return resp, err
+	}
+	resp.Body = &usageBody{
+		ReadCloser: resp.Body,
+		usage: &generationUsage{
+			transport: t,
+			record:    newUsageRecord(req.Context()),
+		},
+		stream: strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"),
+	}
+	return resp, nil
+}
+
+// usageBody passes on a response body and collects its usage. Streams are read by
+// their "data:" lines, other responses as a whole.
+type usageBody struct {
+	io.ReadCloser
+	usage  *generationUsage
+	stream bool
+
+	buf  []byte
+	once sync.Once
+}
+
+func (b *usageBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf = append(b.buf, p[:n]...)
+	for b.stream {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if data, ok := bytes.CutPrefix(bytes.TrimSpace(b.buf[:i]), []byte("data:")); ok {
+			b.usage.add(bytes.TrimSpace(data))
+		}
+		b.buf = b.buf[i+1:]
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *usageBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *usageBody) finish() {
+	b.once.Do(func() {
+		if !b.stream {
+			b.usage.add(b.buf)
+		}
+		b.usage.finish()
+	})
+}
+
+// generationUsage collects the usage of one generation from the response.
+type generationUsage struct {
+	transport *usageTransport
+	record    usageRecord
+	hasUsage  bool
+	hasCost   bool
+}
+
+// add reads a response or stream chunk.
+func (u *generationUsage) add(data []byte) {
+	var resp struct {
+		ID       string `json:"id"`
+		Model    string `json:"model"`
+		Provider string `json:"provider"`
+		Usage    *struct {
+			PromptTokens        int      `json:"prompt_tokens"`
+			CompletionTokens    int      `json:"completion_tokens"`
+			Cost                *float64 `json:"cost"`
+			PromptTokensDetails *struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"prompt_tokens_details"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return
+	}
+	if u.record.GenerationID == "" {
+		u.record.GenerationID = resp.ID
+	}
+	if resp.Model != "" {
+		u.record.Model = resp.Model
+	}
+	if resp.Provider != "" {
+		u.record.Provider = resp.Provider
+	}
+	if resp.Usage == nil || (resp.Usage.PromptTokens == 0 && resp.Usage.CompletionTokens == 0) {
+		return
+	}
+	u.hasUsage = true
+	u.record.PromptTokens = resp.Usage.PromptTokens
+	u.record.CompletionTokens = resp.Usage.CompletionTokens
+	if resp.Usage.PromptTokensDetails != nil {
+		u.record.CachedTokens = resp.Usage.PromptTokensDetails.CachedTokens
+	}
+	if resp.Usage.Cost != nil {
+		u.hasCost = true
+		u.record.Cost = *resp.Usage.Cost
+	}
+}
+
+// finish stores the record, once the missing stats have been looked up.
+func (u *generationUsage) finish() {
+	if u.record.GenerationID == "" && !u.hasUsage {
+		// Nothing was generated
+		return
+	}
+	if !u.hasCost && u.record.GenerationID != "" && u.transport.generationURL != "" {
+		u.transport.queueLookup(u.record)
+		return
+	}
+	usageLog.Add(u.record)
+}
+
+// queueLookup queues a record for lookupGeneration, starting the workers on first use.
+func (t *usageTransport) queueLookup(record usageRecord) {
+	generationLookupsOnce.Do(func() {
+		for i := 0; i < generationLookupWorkers; i++ {
+			go func() {
+				for lookup := range generationLookups {
+					lookup.transport.lookupGeneration(lookup.record)
+				}
+			}()
+		}
+	})
+	select {
+	case generationLookups <- generationLookup{transport: t, record: record}:
+	default:
+		slog.Warn("Too many generation lookups pending, recording the generation without cost", "id", record.GenerationID)
+		usageLog.Add(record)
+	}
+}
+
+// lookupGeneration completes a record with OpenRouter's stats of the generation,
+// which are available shortly after it ends, and stores it.
+func (t *usageTransport) lookupGeneration(record usageRecord) {
+	delay := generationLookupDelay
+	var err error
+	for attempt := 0; attempt < generationLookupAttempts; attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+		if err = t.fetchGeneration(&record); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		slog.Warn("Failed to get the stats of a generation, recording it without cost", "id", record.GenerationID, "Error", err)
+	}
+	usageLog.Add(record)
+}
+
+// fetchGeneration fills in the record from OpenRouter's stats of the generation.
+func (t *usageTransport) fetchGeneration(record *usageRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.generationURL+"?id="+url.QueryEscape(record.GenerationID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return upstreamHTTPError(resp)
+	}
+
+	var stats struct {
+		Data struct {
+			Model                  string  `json:"model"`
+			ProviderName           string  `json:"provider_name"`
+			TotalCost              float64 `json:"total_cost"`
+			TokensPrompt           int     `json:"tokens_prompt"`
+			TokensCompletion       int     `json:"tokens_completion"`
+			NativeTokensPrompt     int     `json:"native_tokens_prompt"`
+			NativeTokensCompletion int     `json:"native_tokens_completion"`
+			NativeTokensCached     int     `json:"native_tokens_cached"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return fmt.Errorf("failed to decode generation stats: %w", err)
+	}
+	data := stats.Data
+	if data.Model != "" {
+		record.Model = data.Model
+	}
+	if data.ProviderName != "" {
+		record.Provider = data.ProviderName
+	}
+	record.Cost = data.TotalCost
+	// Usage accounting reports the provider's own token counts, so prefer those
+	record.PromptTokens, record.CompletionTokens = data.NativeTokensPrompt, data.NativeTokensCompletion
+	if record.PromptTokens == 0 && record.CompletionTokens == 0 {
+		record.PromptTokens, record.CompletionTokens = data.TokensPrompt, data.TokensCompletion
+	}
+	record.CachedTokens = data.NativeTokensCached
+	return nil
+}
+
+// registerUsageRoutes adds /proxy/usage, which sums up the usage records by the
+// dimensions in "group_by" for the days from "from" to "to" (YYYY-MM-DD).
+func registerUsageRoutes(r *gin.Engine) {
+	r.GET("/proxy/usage", requireAdmin, func(c *gin.Context) {
+		groupBy := []string{"day", "model", "api_key", "client"}
+		if value := c.Query("group_by"); value != "" {
+			groupBy = strings.Split(value, ",")
+			for _, dimension := range groupBy {
+				if _, ok := usageDimensions[dimension]; !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown group_by %q, expected day, model, provider, api_key or client", dimension)})
+					return
+				}
+			}
+		}
+
+		from, to := c.Query("from"), c.Query("to")
+		for name, value := range map[string]string{"from": from, "to": to} {
+			if _, err := time.Parse("2006-01-02", value); value != "" && err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s %q, expected YYYY-MM-DD", name, value)})
+				return
+			}
+		}
+
+		groups, total := usageLog.Summary(groupBy, from, to)
+		usage := make([]gin.H, 0, len(groups))
+		for _, group := range groups {
+			entry := gin.H{
+				"requests":          group.Requests,
+				"prompt_tokens":     group.PromptTokens,
+				"completion_tokens": group.CompletionTokens,
+				"cached_tokens":     group.CachedTokens,
+				"cost":              group.Cost,
+			}
+			for i, dimension := range groupBy {
+				entry[dimension] = group.keys[i]
+			}
+			usage = append(usage, entry)
+		}
+		c.JSON(http.StatusOK, gin.H{"usage": usage, "total": total})
+	})
+}
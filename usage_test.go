@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newUsageUpstream serves /chat/completions, streamed or not as the request body
+// says, and /generation with the stats of "gen-lookup".
+func newUsageUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chat/completions":
+			body, _ := io.ReadAll(r.Body)
+			switch {
+			case strings.Contains(string(body), `"abort"`):
+				// A stream the client aborts before the usage chunk
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "data: {\"id\":\"gen-lookup\",\"model\":\"openai/gpt-4o\",\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+			case strings.Contains(string(body), `"stream":true`):
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "data: {\"id\":\"gen-stream\",\"model\":\"openai/gpt-4o\",\"provider\":\"Azure\",\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+				fmt.Fprint(w, "data: {\"id\":\"gen-stream\",\"model\":\"openai/gpt-4o\",\"provider\":\"Azure\",\"choices\":[],\"usage\":{\"prompt_tokens\":20,\"completion_tokens\":5,\"cost\":0.002}}\n\n")
+				fmt.Fprint(w, "data: [DONE]\n\n")
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"id":"gen-1","model":"anthropic/claude-3.5-haiku","provider":"Anthropic","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":3,"cost":0.001,"prompt_tokens_details":{"cached_tokens":4}}}`)
+			}
+		case "/generation":
+			if r.URL.Query().Get("id") != "gen-lookup" || r.Header.Get("Authorization") != "Bearer sk-test" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"model":"openai/gpt-4o","provider_name":"OpenAI","total_cost":0.0005,"tokens_prompt":7,"tokens_completion":1,"native_tokens_prompt":8,"native_tokens_completion":2,"native_tokens_cached":0}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// usageRecords returns the totals of the usage log by generation, i.e. by model
+// and provider, once it has the number of requests.
+func usageRecords(t *testing.T, requests int) map[string]usageTotals {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		groups, total := usageLog.Summary([]string{"model", "provider"}, "", "")
+		if total.Requests >= requests {
+			records := make(map[string]usageTotals, len(groups))
+			for _, group := range groups {
+				records[strings.Join(group.keys, " ")] = group.usageTotals
+			}
+			return records
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d usage records, want %d", total.Requests, requests)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUsageTransport(t *testing.T) {
+	upstream := newUsageUpstream(t)
+	defer upstream.Close()
+
+	savedLog, savedDelay := usageLog, generationLookupDelay
+	defer func() { usageLog, generationLookupDelay = savedLog, savedDelay }()
+	usageLog = newUsageStore("")
+	generationLookupDelay = time.Millisecond
+
+	client := &http.Client{Transport: &usageTransport{
+		base:          http.DefaultTransport,
+		generationURL: upstream.URL + "/generation",
+		apiKey:        "sk-test",
+	}}
+	ctx := withUsageLabels(context.Background(), usageLabels{APIKey: "alice", Client: "ide"})
+	for _, body := range []string{`{"stream":false}`, `{"stream":true}`, `{"stream":true,"user":"abort"}`} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL+"/chat/completions", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	got := usageRecords(t, 3)
+	want := map[string]usageTotals{
+		"anthropic/claude-3.5-haiku Anthropic": {Requests: 1, PromptTokens: 10, CompletionTokens: 3, CachedTokens: 4, Cost: 0.001},
+		"openai/gpt-4o Azure":                  {Requests: 1, PromptTokens: 20, CompletionTokens: 5, Cost: 0.002},
+		"openai/gpt-4o OpenAI":                 {Requests: 1, PromptTokens: 8, CompletionTokens: 2, Cost: 0.0005},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("usage by generation = %+v, want %+v", got, want)
+	}
+
+	groups, total := usageLog.Summary([]string{"api_key", "client"}, "", "")
+	if len(groups) != 1 || groups[0].keys[0] != "alice" || groups[0].keys[1] != "ide" {
+		t.Errorf("usage by key and client = %+v, want one group for alice and ide", groups)
+	}
+	if total.Requests != 3 || total.PromptTokens != 38 || total.CompletionTokens != 10 {
+		t.Errorf("total = %+v, want 3 requests with 38 prompt and 10 completion tokens", total)
+	}
+}
+
+func TestUsageStoreSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	store := newUsageStore(path)
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.Local) }
+	records := []usageRecord{
+		{Time: day(1), GenerationID: "gen-1", Model: "openai/gpt-4o", APIKey: "alice", PromptTokens: 10, Cost: 0.01},
+		{Time: day(1), GenerationID: "gen-2", Model: "openai/gpt-4o", APIKey: "bob", PromptTokens: 20, Cost: 0.02},
+		{Time: day(2), GenerationID: "gen-3", Model: "openai/gpt-4o-mini", APIKey: "alice", PromptTokens: 30, Cost: 0.03},
+		{Time: day(3), GenerationID: "gen-4", Model: "openai/gpt-4o", APIKey: "alice", PromptTokens: 40, Cost: 0.04},
+	}
+	for _, record := range records {
+		store.Add(record)
+	}
+
+	// The totals are the same after reading the records back from the file
+	loaded, err := loadUsageStore(path)
+	if err != nil {
+		t.Fatalf("loadUsageStore() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		groupBy  []string
+		from, to string
+		want     map[string]int // prompt tokens by group
+	}{
+		{name: "by model", groupBy: []string{"model"}, want: map[string]int{"openai/gpt-4o": 70, "openai/gpt-4o-mini": 30}},
+		{name: "by day and key", groupBy: []string{"day", "api_key"}, want: map[string]int{
+			"2026-10-01 alice": 10, "2026-10-01 bob": 20, "2026-10-02 alice": 30, "2026-10-03 alice": 40,
+		}},
+		{name: "from", groupBy: []string{"api_key"}, from: "2026-10-02", want: map[string]int{"alice": 70}},
+		{name: "from and to", groupBy: []string{"model"}, from: "2026-10-01", to: "2026-10-02", want: map[string]int{"openai/gpt-4o": 30, "openai/gpt-4o-mini": 30}},
+		{name: "no groups", want: map[string]int{"": 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, s := range []*usageStore{store, loaded} {
+				groups, _ := s.Summary(tt.groupBy, tt.from, tt.to)
+				got := make(map[string]int, len(groups))
+				for _, group := range groups {
+					got[strings.Join(group.keys, " ")] = group.PromptTokens
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Summary() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyVersion is set at build time with -ldflags "-X main.proxyVersion=...".
+var proxyVersion = "dev"
+
+// defaultOllamaVersion is the Ollama version reported to clients, recent enough
+// for them to use the endpoints the proxy implements.
+const defaultOllamaVersion = "0.9.0"
+
+// registerVersionRoutes adds /api/version. Clients check "version" against
+// Ollama's releases; the proxy's own build is reported separately.
+func registerVersionRoutes(r *gin.Engine) {
+	ollamaVersion := os.Getenv("OLLAMA_VERSION")
+	if ollamaVersion == "" {
+		ollamaVersion = defaultOllamaVersion
+	}
+	build := gin.H{"version": proxyVersion}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build["go_version"] = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				build["revision"] = setting.Value
+			case "vcs.time":
+				build["build_time"] = setting.Value
+			case "vcs.modified":
+				build["modified"] = setting.Value == "true"
+			}
+		}
+	}
+
+	r.GET("/api/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"version": ollamaVersion, "proxy": build})
+	})
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// virtualModels holds the models created with /api/create.
+var virtualModels *modelStore
+
+// Model names as Ollama accepts them, e.g. "mario", "mario:latest" or "team/mario:v2"
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*(:[A-Za-z0-9._-]+)?$`)
+
+// normalizeModelName adds the ":latest" tag to names without a tag, like Ollama.
+func normalizeModelName(name string) string {
+	if strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+// modelStore keeps created models in a JSON file, so they survive restarts.
+type modelStore struct {
+	path string
+
+	mu     sync.RWMutex
+	models map[string]*modelAlias // Stored models are never modified, only replaced
+}
+
+// loadModelStore reads the created models. A missing file is an empty store.
+func loadModelStore(path string) (*modelStore, error) {
+	store := &modelStore{path: path, models: make(map[string]*modelAlias)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.models); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// Get returns the created model of the name, or nil. A name without a tag
+// finds the model tagged ":latest".
+func (s *modelStore) Get(name string) *modelAlias {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if model, ok := s.models[name]; ok {
+		return model
+	}
+	return s.models[normalizeModelName(name)]
+}
+
+// All returns a copy of the created models by name.
+func (s *modelStore) All() map[string]*modelAlias {
+	models := make(map[string]*modelAlias)
+	if s == nil {
+		return models
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for name, model := range s.models {
+		models[name] = model
+	}
+	return models
+}
+
+// Put stores a model under the name, replacing a model of the same name.
+func (s *modelStore) Put(name string, model *modelAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.models[name]
+	s.models[name] = model
+	if err := s.save(); err != nil {
+		if existed {
+			s.models[name] = previous
+		} else {
+			delete(s.models, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes a model and reports whether it existed.
+func (s *modelStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.models[name]; !ok {
+		name = normalizeModelName(name)
+	}
+	previous, ok := s.models[name]
+	if !ok {
+		return false, nil
+	}
+	delete(s.models, name)
+	if err := s.save(); err != nil {
+		s.models[name] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the store to a temporary file first, so a failed write does not
+// destroy the stored models. The caller holds the lock.
+func (s *modelStore) save() error {
+	data, err := json.MarshalIndent(s.models, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save models: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save models: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to save models: %w", err)
+	}
+	if err := os.Rename(file.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save models: %w", err)
+	}
+	return nil
+}
+
+// knownModel reports whether a resolved model exists, for providers that know their models.
+func knownModel(ctx context.Context, provider Provider, fullName string) bool {
+	lister, ok := provider.(modelIDLister)
+	if !ok {
+		return true
+	}
+	ids, err := lister.ModelIDs(ctx)
+	if err != nil || len(ids) == 0 {
+		return true
+	}
+	id, _ := splitRoutingVariant(fullName)
+	return containsString(ids, id)
+}
+
+// createModel builds a model from an /api/create request. FROM can name a model of
+// the backends or an alias or created model, whose settings are then inherited.
+func createModel(ctx context.Context, provider Provider, name string, from string, modelfile *parsedModelfile) (*modelAlias, error) {
+	model, err := storedModelBase(ctx, provider, name, from)
+	if err != nil {
+		return nil, err
+	}
+	model.Options = model.options()
+	model.Temperature, model.MaxTokens = nil, 0
+
+	if modelfile.System != nil {
+		model.System = *modelfile.System
+	}
+	if modelfile.Template != nil {
+		model.Template = *modelfile.Template
+	}
+	if len(modelfile.Parameters) > 0 {
+		options := make(map[string]interface{}, len(model.Options)+len(modelfile.Parameters))
+		for key, value := range model.Options {
+			options[key] = value
+		}
+		for key, value := range modelfile.Parameters {
+			options[key] = value
+		}
+		model.Options = options
+	}
+	if len(modelfile.Messages) > 0 {
+		model.Messages = modelfile.Messages
+	}
+	model.ModifiedAt = time.Now().Format(time.RFC3339)
+	return model, nil
+}
+
+// copyModel returns the definition for a copy of a model. Copies of aliases and
+// created models get their settings; copies of upstream models are plain aliases.
+func copyModel(ctx context.Context, provider Provider, name string, source string) (*modelAlias, error) {
+	model, err := storedModelBase(ctx, provider, name, source)
+	if err != nil {
+		return nil, err
+	}
+	model.ModifiedAt = time.Now().Format(time.RFC3339)
+	return model, nil
+}
+
+// storedModelBase returns a copy of the alias or created model that a model stored
+// under the name is based on, or a plain alias of an upstream model. The client's
+// API key has to allow the model it resolves to, under both names.
+func storedModelBase(ctx context.Context, provider Provider, name string, from string) (*modelAlias, error) {
+	fullName, base, err := resolveChatModel(ctx, provider, from)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := splitRoutingVariant(fullName)
+	if err := requestKey(ctx).checkModel(filterTarget{name: name, id: id, meta: catalogModel(ctx, provider, id)}); err != nil {
+		return nil, err
+	}
+
+	model := &modelAlias{}
+	if base != nil {
+		*model = *base
+		return model, nil
+	}
+	if !knownModel(ctx, provider, fullName) {
+		return nil, fmt.Errorf("%w: %s", errModelNotFound, from)
+	}
+	model.Model = fullName
+	return model, nil
+}
+
+// storeModelName validates the name of a model to store and adds the default tag.
+func storeModelName(name string) (string, error) {
+	if !modelNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid model name %q", name)
+	}
+	if _, ok := modelAliases[name]; ok {
+		return "", fmt.Errorf("%q is configured in the aliases file", name)
+	}
+	name = normalizeModelName(name)
+	if _, ok := modelAliases[name]; ok {
+		return "", fmt.Errorf("%q is configured in the aliases file", name)
+	}
+	return name, nil
+}
+
+// modelStoreError answers a failed lookup of the model a store request refers to.
+func modelStoreError(c *gin.Context, message string, err error) {
+	var ambiguous *ambiguousModelError
+	if errors.Is(err, errModelNotFound) || errors.Is(err, errModelNotAllowed) || errors.Is(err, errModelNotAllowedForKey) || errors.As(err, &ambiguous) {
+		c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	handleUpstreamError(c, message, err)
+}
+
+// registerModelStoreRoutes adds /api/create for models defined by a Modelfile,
+// and /api/copy and /api/delete for managing them.
+func registerModelStoreRoutes(r *gin.Engine, provider Provider) {
+	r.POST("/api/create", requireAdmin, func(c *gin.Context) {
+		var request struct {
+			Model      string                 `json:"model"`
+			Name       string                 `json:"name"` // Older clients
+			Modelfile  string                 `json:"modelfile"`
+			From       string                 `json:"from"`
+			System     *string                `json:"system"`
+			Template   *string                `json:"template"`
+			Parameters map[string]interface{} `json:"parameters"`
+			Messages   []modelMessage         `json:"messages"`
+			Adapters   map[string]string      `json:"adapters"`
+			Stream     *bool                  `json:"stream"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		name := request.Model
+		if name == "" {
+			name = request.Name
+		}
+		name, err := storeModelName(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(request.Adapters) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "adapters are not supported for remote models"})
+			return
+		}
+
+		// The fields of the request take precedence over the Modelfile
+		modelfile, err := parseModelfile(request.Modelfile)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid modelfile: " + err.Error()})
+			return
+		}
+		if request.From != "" {
+			modelfile.From = request.From
+		}
+		if request.System != nil {
+			modelfile.System = request.System
+		}
+		if request.Template != nil {
+			modelfile.Template = request.Template
+		}
+		if err := validateParameters(request.Parameters); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for key, value := range request.Parameters {
+			modelfile.Parameters[key] = value
+		}
+		if len(request.Messages) > 0 {
+			modelfile.Messages = request.Messages
+		}
+		if modelfile.From == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "neither 'from' nor a FROM instruction was given"})
+			return
+		}
+
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		model, err := createModel(ctx, provider, name, modelfile.From, modelfile)
+		if err != nil {
+			modelStoreError(c, "Error creating model", err)
+			return
+		}
+		if err := virtualModels.Put(name, model); err != nil {
+			slog.Error("Error saving created model", "model", name, "Error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		slog.Info("Created model", "model", name, "from", model.Model)
+
+		statuses := []string{"reading model metadata", "using model " + model.Model}
+		if model.System != "" {
+			statuses = append(statuses, "creating system layer")
+		}
+		if len(model.Options) > 0 {
+			statuses = append(statuses, "creating parameters layer")
+		}
+		statuses = append(statuses, "writing manifest", "success")
+
+		if request.Stream != nil && !*request.Stream {
+			c.JSON(http.StatusOK, gin.H{"status": "success"})
+			return
+		}
+		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		for _, status := range statuses {
+			line, _ := json.Marshal(gin.H{"status": status})
+			c.Writer.Write(append(line, '\n'))
+			c.Writer.Flush()
+		}
+	})
+
+	r.POST("/api/copy", requireAdmin, func(c *gin.Context) {
+		var request struct {
+			Source      string `json:"source"`
+			Destination string `json:"destination"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		if request.Source == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source is required"})
+			return
+		}
+		destination, err := storeModelName(request.Destination)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := upstreamContext(c)
+		defer cancel()
+
+		model, err := copyModel(ctx, provider, destination, request.Source)
+		if err != nil {
+			modelStoreError(c, "Error copying model", err)
+			return
+		}
+		if err := virtualModels.Put(destination, model); err != nil {
+			slog.Error("Error saving copied model", "model", destination, "Error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		slog.Info("Copied model", "source", request.Source, "destination", destination, "model", model.Model)
+		c.Status(http.StatusOK)
+	})
+
+	r.DELETE("/api/delete", requireAdmin, func(c *gin.Context) {
+		var request struct {
+			Model string `json:"model"`
+			Name  string `json:"name"` // Older clients
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		name := request.Model
+		if name == "" {
+			name = request.Name
+		}
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+			return
+		}
+
+		if _, ok := modelAliases[name]; ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q is configured in the aliases file and cannot be deleted", name)})
+			return
+		}
+
+		// Only local definitions can be deleted; upstream models stay untouched
+		deleted, err := virtualModels.Delete(name)
+		if err != nil {
+			slog.Error("Error deleting model", "model", name, "Error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", name)})
+			return
+		}
+		slog.Info("Deleted model", "model", name)
+		c.Status(http.StatusOK)
+	})
+}